@@ -25,6 +25,13 @@ function build {
    rm $1.go
 }
 
+function build_module {
+   test -e exec && rm exec
+   cp $1.src $1.go
+   go build -o exec $1.go
+   rm $1.go
+}
+
 function build_main {
    test -e exec && rm exec
    cp ../common/gobuild.py.launcher.go $1.go
@@ -33,6 +40,8 @@ function build_main {
    rm $1.go
 }
 
+# the proxy checking the Go actions for common/gobuild.py
+go build -o proxy ../..
 
 build hi
 zip -q hi.zip exec
@@ -46,6 +55,9 @@ build_main hello_greeting
 zip -q hello_greeting.zip exec
 cp exec hello_greeting
 
+build_module loop
+cp exec loop
+
 test -e hello.zip && rm hello.zip
 cd src
 zip -q -r ../hello.zip main.go hello
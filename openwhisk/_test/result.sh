@@ -0,0 +1,27 @@
+#!/bin/bash
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+while read line
+do
+   kind="$(echo $line | jq -r .value.kind)"
+   case "$kind" in
+   csv) echo '{"__ow_result": {"content_type": "text/csv", "body": "a,b\n1,2\n"}}' >&3 ;;
+   bin) echo '{"__ow_result": {"body": "AAEC/w==", "encoding": "base64"}}' >&3 ;;
+   bad) echo '{"__ow_result": {"body": "AAEC/w==", "encoding": "rot13"}}' >&3 ;;
+   *) echo '{"kind": "'$kind'"}' >&3 ;;
+   esac
+done
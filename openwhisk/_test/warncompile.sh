@@ -0,0 +1,23 @@
+#!/bin/bash
+#
+# Licensed to the Apache Software Foundation (ASF) under one or more
+# contributor license agreements.  See the NOTICE file distributed with
+# this work for additional information regarding copyright ownership.
+# The ASF licenses this file to You under the Apache License, Version 2.0
+# (the "License"); you may not use this file except in compliance with
+# the License.  You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# a compiler printing a warning but succeeding, as told by the diagnostics
+echo "warning in stderr" >&2
+if test -n "$__OW_DIAGNOSTICS"
+then echo '[{"file":"exec","line":1,"severity":"warning","message":"warning in stderr"}]' >"$__OW_DIAGNOSTICS"
+fi
+cp "$2/exec" "$3/exec"
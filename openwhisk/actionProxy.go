@@ -19,14 +19,17 @@ package openwhisk
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type ProxyMode int
@@ -40,6 +43,17 @@ const (
 	ProxyModeServer
 )
 
+// String returns the name of the mode, as shown in the logs
+func (mode ProxyMode) String() string {
+	switch mode {
+	case ProxyModeClient:
+		return "client"
+	case ProxyModeServer:
+		return "server"
+	}
+	return "none"
+}
+
 type ClientProxyData struct {
 	ProxyActionID  string
 	ActionCodeHash string
@@ -80,8 +94,8 @@ type ActionProxy struct {
 	// index current dir
 	currentDir int
 
-	// theChannel is the channel communicating with the action
-	theExecutor *Executor
+	// versions of the action, the current one running the activations
+	versions *versionRegistry
 
 	// out and err files
 	outFile *os.File
@@ -89,11 +103,42 @@ type ActionProxy struct {
 
 	// environment
 	env map[string]string
+
+	// customCompiler is the compiler set with SetCompiler, used instead of the named one
+	customCompiler Compiler
+
+	// recorder records the activations, if enabled with OW_RECORD_FILE
+	recorder *recorder
+
+	// tracer exports the spans of the requests, if enabled with OTEL_EXPORTER_OTLP_ENDPOINT
+	tracer *tracer
+
+	// logger logs the events of the proxy, with its mode
+	logger *slog.Logger
+
+	// config is the configuration of the proxy
+	config *Config
+
+	// initMu runs the initializations one at a time, the ones of /init and the reloads of WatchAction,
+	// guarding initialized, env and currentDir
+	initMu sync.Mutex
 }
 
-// NewActionProxy creates a new action proxy that can handle http requests
-func NewActionProxy(baseDir string, compiler string, outFile *os.File, errFile *os.File, proxyMode ProxyMode) *ActionProxy {
+// NewActionProxy creates a new action proxy that can handle http requests,
+// storing the actions in the directory and running them in the mode of the configuration
+func NewActionProxy(config *Config, outFile *os.File, errFile *os.File) *ActionProxy {
+	baseDir, proxyMode := config.ActionDir, config.ProxyMode
 	os.Mkdir(baseDir, 0755)
+	logger := Logger.With("mode", proxyMode.String())
+
+	// the activations are recorded only when running the actions directly
+	var rec *recorder
+	if proxyMode == ProxyModeNone {
+		var err error
+		if rec, outFile, errFile, err = newRecorder(config, outFile, errFile); err != nil {
+			logger.Error("cannot record the activations", "error", err)
+		}
+	}
 
 	return &ActionProxy{
 		proxyMode,
@@ -101,13 +146,36 @@ func NewActionProxy(baseDir string, compiler string, outFile *os.File, errFile *
 		nil,
 		false,
 		baseDir,
-		compiler,
+		config.Compiler,
 		highestDir(baseDir),
-		nil,
+		&versionRegistry{keep: config.KeepVersions},
 		outFile,
 		errFile,
 		map[string]string{},
+		nil,
+		rec,
+		loadTracer(),
+		logger,
+		config,
+		sync.Mutex{},
+	}
+}
+
+// activationLogger returns the logger of the proxy with the id of the activation in the body of a run, if any
+func (ap *ActionProxy) activationLogger(body []byte) *slog.Logger {
+	var request struct {
+		ActivationID string `json:"activation_id"`
+		Context      string `json:"context"`
+	}
+	json.Unmarshal(body, &request)
+	var ctx ActivationContext
+	if json.Unmarshal([]byte(request.Context), &ctx) == nil && ctx.ActivationID != "" {
+		request.ActivationID = ctx.ActivationID
+	}
+	if request.ActivationID == "" {
+		return ap.logger
 	}
+	return ap.logger.With("activation_id", request.ActivationID)
 }
 
 // SetEnv sets the environment
@@ -115,14 +183,15 @@ func (ap *ActionProxy) SetEnv(env map[string]interface{}) {
 	// Propagate proxy version
 	ap.env["__OW_PROXY_VERSION"] = Version
 	// propagate OW_EXECUTION_ENV as  __OW_EXECUTION_ENV
-	ee := os.Getenv("OW_EXECUTION_ENV")
-	if ee != "" {
-		ap.env["__OW_EXECUTION_ENV"] = ee
+	if ap.config.ExecutionEnv != "" {
+		ap.env["__OW_EXECUTION_ENV"] = ap.config.ExecutionEnv
 	}
-	// require an ack
-	wa := os.Getenv("OW_WAIT_FOR_ACK")
-	if wa != "" {
-		ap.env["__OW_WAIT_FOR_ACK"] = wa
+	// require an ack, passing the value of OW_WAIT_FOR_ACK as is, as the launchers may check it
+	if ap.config.WaitForAck {
+		ap.env["__OW_WAIT_FOR_ACK"] = "1"
+		if value := os.Getenv("OW_WAIT_FOR_ACK"); value != "" {
+			ap.env["__OW_WAIT_FOR_ACK"] = value
+		}
 	}
 	// propagate all the variables starting with "__OW_"
 	for _, v := range os.Environ() {
@@ -156,12 +225,12 @@ func (ap *ActionProxy) StartLatestAction() error {
 	highestDir := highestDir(ap.baseDir)
 	if highestDir == 0 {
 		Debug("no action found")
-		ap.theExecutor = nil
+		ap.versions.stop()
 		return fmt.Errorf("no valid actions available")
 	}
 
 	// check version
-	execEnv := os.Getenv("OW_EXECUTION_ENV")
+	execEnv := ap.config.ExecutionEnv
 	if execEnv != "" {
 		execEnvFile := fmt.Sprintf("%s/%d/bin/exec.env", ap.baseDir, highestDir)
 		execEnvData, err := os.ReadFile(execEnvFile)
@@ -174,36 +243,90 @@ func (ap *ActionProxy) StartLatestAction() error {
 		}
 	}
 
-	// save the current executor
-	curExecutor := ap.theExecutor
-
-	// try to launch the action
-	executable := fmt.Sprintf("%s/%d/bin/exec", ap.baseDir, highestDir)
-	os.Chmod(executable, 0755)
-	newExecutor := NewExecutor(ap.outFile, ap.errFile, executable, ap.env)
-	Debug("starting %s", executable)
+	// resource limits of the action
+	limits, err := loadLimits(ap.env)
+	if err != nil {
+		return err
+	}
 
-	// start executor
-	err := newExecutor.Start(os.Getenv("OW_WAIT_FOR_ACK") != "")
+	// start the new version, swapping it with the current one when it is ready
+	newExecutor, err := ap.versionExecutor(highestDir, limits)
+	if err != nil {
+		return err
+	}
+	err = ap.startVersion(highestDir, newExecutor)
 	if err == nil {
-		ap.theExecutor = newExecutor
-		if curExecutor != nil {
-			Debug("stopping old executor")
-			curExecutor.Stop()
-		}
 		return nil
 	}
 
 	// cannot start, removing the action
-	// and leaving the current executor running
+	// and leaving the current executor running, unless debugging it
+	ap.logger.Warn("cannot start the action", "version", highestDir, "error", err)
 	if !Debugging {
-		exeDir := fmt.Sprintf("./action/%d/", highestDir)
+		exeDir := fmt.Sprintf("%s/%d/", ap.baseDir, highestDir)
 		Debug("removing the failed action in %s", exeDir)
 		os.RemoveAll(exeDir)
 	}
+
+	// if it is not running, go back to the previous versions
+	if !ap.versions.running() {
+		for _, number := range ap.versions.previous() {
+			if number == highestDir {
+				continue
+			}
+			Debug("rolling back to the version %d", number)
+			if executor, err := ap.versionExecutor(number, limits); err == nil && ap.startVersion(number, executor) == nil {
+				break
+			}
+		}
+	}
 	return err
 }
 
+// versionExecutor prepares the executor of the version of the action in the numbered folder
+func (ap *ActionProxy) versionExecutor(number int, limits ActionLimits) (*Executor, error) {
+	dir := fmt.Sprintf("%s/%d", ap.baseDir, number)
+
+	// hardening of the action
+	sandbox, err := newSandbox(ap.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := sandbox.protectDir(dir); err != nil {
+		sandbox.release()
+		return nil, err
+	}
+
+	executable := dir + "/bin/exec"
+	os.Chmod(executable, 0755)
+	var executor *Executor
+	if command, args, ok := wasmCommand(executable); ok {
+		Debug("the action is a WASM module")
+		if !childEnabled.Load() {
+			sandbox.release()
+			return nil, fmt.Errorf("cannot run the WASM action: %w", errNoRunChild)
+		}
+		executor = NewExecutor(ap.outFile, ap.errFile, command, ap.env, args...)
+	} else {
+		executor = NewExecutor(ap.outFile, ap.errFile, executable, ap.env)
+	}
+	executor.limits = limits
+	executor.sandbox = sandbox
+	executor.stopSignal, executor.stopGrace = ap.config.StopSignal, ap.config.StopGrace
+	return executor, nil
+}
+
+// startVersion starts the executor of a version, making it the current one
+func (ap *ActionProxy) startVersion(number int, executor *Executor) error {
+	Debug("starting the version %d", number)
+	if err := executor.Start(ap.config.WaitForAck); err != nil {
+		executor.Stop()
+		return err
+	}
+	ap.versions.swap(&ActionVersion{Number: number, Dir: fmt.Sprintf("%s/%d", ap.baseDir, number), executor: executor})
+	return nil
+}
+
 func (ap *ActionProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/init":
@@ -228,12 +351,6 @@ func (ap *ActionProxy) Start(port int) {
 // ExtractAndCompileIO read in input and write in output to use the runtime as a compiler "on-the-fly"
 func (ap *ActionProxy) ExtractAndCompileIO(r io.Reader, w io.Writer, main string, env string) {
 
-	// read the std input
-	in, err := io.ReadAll(r)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	envMap := make(map[string]interface{})
 	if env != "" {
 		json.Unmarshal([]byte(env), &envMap)
@@ -241,7 +358,14 @@ func (ap *ActionProxy) ExtractAndCompileIO(r io.Reader, w io.Writer, main string
 	ap.SetEnv(envMap)
 
 	// extract and compile it
-	file, err := ap.ExtractAndCompile(&in, main)
+	file, err := ap.ExtractAndCompileFrom(r, main)
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		// report the diagnostics as the init does, for the tools building the actions
+		buf, _ := json.Marshal(ErrResponse{Error: compileErr.Error(), Diagnostics: compileErr.Diagnostics})
+		fmt.Fprintln(os.Stderr, string(buf))
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
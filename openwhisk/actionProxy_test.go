@@ -50,45 +50,45 @@ func Example_startTestServer() {
 func TestStartLatestAction_emit1(t *testing.T) {
 	os.RemoveAll("./action/t2")
 	logf, _ := os.CreateTemp("/tmp", "log")
-	ap := NewActionProxy("./action/t2", "", logf, logf, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/t2", "", ProxyModeNone), logf, logf)
 	// start the action that emits 1
 	buf := []byte("#!/bin/sh\nwhile read a; do echo 1 >&3 ; done\n")
 	ap.ExtractAction(&buf, "bin")
 	ap.StartLatestAction()
-	res, _ := ap.theExecutor.Interact([]byte("x"))
+	res, _ := ap.versions.executor().Interact([]byte("x"))
 	assert.Equal(t, res, []byte("1\n"))
-	ap.theExecutor.Stop()
+	ap.versions.stop()
 }
 
 func TestStartLatestAction_terminate(t *testing.T) {
 	os.RemoveAll("./action/t3")
 	logf, _ := os.CreateTemp("/tmp", "log")
-	ap := NewActionProxy("./action/t3", "", logf, logf, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/t3", "", ProxyModeNone), logf, logf)
 	// now start an action that terminate immediately
 	buf := []byte("#!/bin/sh\ntrue\n")
 	ap.ExtractAction(&buf, "bin")
 	ap.StartLatestAction()
-	assert.Nil(t, ap.theExecutor)
+	assert.Nil(t, ap.versions.executor())
 }
 
 func TestStartLatestAction_emit2(t *testing.T) {
 	os.RemoveAll("./action/t4")
 	logf, _ := os.CreateTemp("/tmp", "log")
-	ap := NewActionProxy("./action/t4", "", logf, logf, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/t4", "", ProxyModeNone), logf, logf)
 	// start the action that emits 2
 	buf := []byte("#!/bin/sh\nwhile read a; do echo 2 >&3 ; done\n")
 	ap.ExtractAction(&buf, "bin")
 	ap.StartLatestAction()
-	res, _ := ap.theExecutor.Interact([]byte("z"))
+	res, _ := ap.versions.executor().Interact([]byte("z"))
 	assert.Equal(t, res, []byte("2\n"))
 	/**/
-	ap.theExecutor.Stop()
+	ap.versions.stop()
 }
 
 func Example_compile_bin() {
 	os.RemoveAll("./action/c1")
 	logf, _ := os.CreateTemp("/tmp", "log")
-	ap := NewActionProxy("./action/c1", "_test/compile.py", logf, logf, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/c1", "_test/compile.py", ProxyModeNone), logf, logf)
 	dat, _ := Zip("_test/pysample")
 	inp := bytes.NewBuffer(dat)
 	out := new(bytes.Buffer)
@@ -108,7 +108,7 @@ func Example_compile_bin() {
 func Example_compile_src() {
 	os.RemoveAll("./action/c2")
 	logf, _ := os.CreateTemp("/tmp", "log")
-	ap := NewActionProxy("./action/c2", "_test/compile.py", logf, logf, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/c2", "_test/compile.py", ProxyModeNone), logf, logf)
 	log.Println(io.ReadAll(logf))
 	dat, _ := Zip("_test/pysample/lib")
 	inp := bytes.NewBuffer(dat)
@@ -144,8 +144,8 @@ func Example_badcompile() {
 
 }
 
-func Example_SetEnv() {
-	ap := NewActionProxy("", "", nil, nil, ProxyModeNone)
+func Example_setEnv() {
+	ap := NewActionProxy(testConfig("", "", ProxyModeNone), nil, nil)
 	fmt.Println(ap.env)
 	var m map[string]interface{}
 	json.Unmarshal([]byte(`{
@@ -178,7 +178,8 @@ func Example_executionEnv_check() {
 	ts, cur, log := startTestServer("")
 	res, _, _ := doPost(ts.URL+"/init", initBinary("_test/helloack.zip", "main"))
 	fmt.Print(res)
-	os.Setenv("OW_EXECUTION_ENV", "exec/env")
+	// the configuration is loaded with the proxy
+	ts.Config.Handler.(*ActionProxy).config.ExecutionEnv = "exec/env"
 	res, _, _ = doPost(ts.URL+"/init", initBinary("_test/helloack.zip", "main"))
 	fmt.Print(res)
 	stopTestServer(ts, cur, log)
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package actionloop
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Handler serves one activation: value is the JSON payload of the request,
+// and the returned result is encoded as JSON
+type Handler func(ctx context.Context, value json.RawMessage) (interface{}, error)
+
+// Serve runs the action loop on the standard input and on file descriptor 3,
+// until the input is closed. When OW_DEBUG is set, the requests and the results
+// are logged in the file it names, or else in /tmp/action.log.
+func Serve(handler Handler) error {
+	out := os.NewFile(3, "pipe")
+	defer out.Close()
+	var logger *log.Logger
+	if debug := os.Getenv("OW_DEBUG"); debug != "" {
+		if !filepath.IsAbs(debug) {
+			debug = "/tmp/action.log"
+		}
+		if f, err := os.OpenFile(debug, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666); err == nil {
+			defer f.Close()
+			logger = log.New(f, "", log.LstdFlags)
+			logger.Printf("Environment: %v", os.Environ())
+		}
+	}
+	return serve(os.Stdin, out, os.Getenv("__OW_WAIT_FOR_ACK") != "", logger, handler)
+}
+
+// serve implements the loop, logging when logger is not nil
+func serve(in io.Reader, out io.Writer, ack bool, logger *log.Logger, handler Handler) error {
+	debug := func(format string, args ...interface{}) {
+		if logger != nil {
+			logger.Printf(format, args...)
+		}
+	}
+	// acknowledgement of started action
+	if ack {
+		if _, err := fmt.Fprintf(out, "{\"ok\":true}\n"); err != nil {
+			return err
+		}
+	}
+	debug("action started")
+
+	reader := bufio.NewReader(in)
+	for {
+		// read one line, also the last one when it is not terminated
+		line, err := reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(bytes.TrimSpace(line)) == 0) {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		debug(">>>'%s'>>>", line)
+		result, err := invoke(line, handler)
+		output := encode(result, err)
+		debug("<<<'%s'<<<", output)
+		if _, err := fmt.Fprintf(out, "%s\n", output); err != nil {
+			return err
+		}
+	}
+}
+
+// invoke the handler with a request, exporting its string fields as environment variables
+// and cancelling the context at the deadline of the activation
+func invoke(line []byte, handler Handler) (interface{}, error) {
+	var input map[string]json.RawMessage
+	if err := json.Unmarshal(line, &input); err != nil {
+		return nil, err
+	}
+	for k, v := range input {
+		if k == "value" {
+			continue
+		}
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			os.Setenv("__OW_"+strings.ToUpper(k), s)
+		}
+	}
+	activation := decodeActivation(input)
+	ctx := context.WithValue(context.Background(), activationKey{}, activation)
+	if deadline := activation.DeadlineTime(); !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	return handler(ctx, input["value"])
+}
+
+// encode the result, or the error as a {"error": ...} result
+func encode(result interface{}, err error) []byte {
+	var output []byte
+	if err == nil {
+		output, err = json.Marshal(result)
+		if err != nil {
+			err = fmt.Errorf("cannot encode the result: %v", err)
+		}
+	}
+	if err != nil {
+		output, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return output
+}
@@ -0,0 +1,152 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package actionloop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type greetRequest struct {
+	Name string `json:"name"`
+}
+
+type greetResponse struct {
+	Greeting string `json:"greeting"`
+}
+
+func greet(ctx context.Context, req greetRequest) (*greetResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	return &greetResponse{Greeting: "Hello, " + req.Name}, nil
+}
+
+func run(t *testing.T, ack bool, handler Handler, lines ...string) string {
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(lines, "\n") + "\n")
+	require.NoError(t, serve(in, &out, ack, nil, handler))
+	return out.String()
+}
+
+func TestServe(t *testing.T) {
+	handler, err := NewHandler(greet)
+	require.NoError(t, err)
+	out := run(t, true, handler,
+		`{"value":{"name":"Mike"}}`,
+		`{"value":{}}`,
+		`{"value":{"name":1}}`,
+		`not json`,
+		`{"value":{"name":"Mike"},"deadline":"1"}`,
+	)
+	require.Equal(t, `{"ok":true}
+{"greeting":"Hello, Mike"}
+{"error":"missing name"}
+{"error":"cannot decode the input: json: cannot unmarshal number into Go struct field greetRequest.name of type string"}
+{"error":"invalid character 'o' in literal null (expecting 'u')"}
+{"error":"context deadline exceeded"}
+`, out)
+
+	// no ack unless required, and results that cannot be encoded are errors
+	out = run(t, false, func(ctx context.Context, value json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"f": func() {}}, nil
+	}, `{"value":{}}`)
+	require.Equal(t, `{"error":"cannot encode the result: json: unsupported type: func()"}
+`, out)
+
+	// the last request is served also without a newline at the end of the input
+	var buf bytes.Buffer
+	require.NoError(t, serve(strings.NewReader(`{"value":{"name":"Mike"}}`), &buf, false, nil, handler))
+	require.Equal(t, `{"greeting":"Hello, Mike"}
+`, buf.String())
+}
+
+func TestServe_activation(t *testing.T) {
+	var seen []*Activation
+	var deadlines []time.Time
+	handler := func(ctx context.Context, value json.RawMessage) (interface{}, error) {
+		a, ok := FromContext(ctx)
+		require.True(t, ok)
+		seen = append(seen, a)
+		d, _ := ctx.Deadline()
+		deadlines = append(deadlines, d)
+		return map[string]string{"id": os.Getenv("__OW_ACTIVATION_ID")}, nil
+	}
+	ctx, _ := json.Marshal(`{"version":1,"activation_id":"a1","namespace":"ns","deadline":4102444800000,"headers":{"x-id":"1"}}`)
+	out := run(t, false, handler,
+		`{"value":{},"activation_id":"a1","context":`+string(ctx)+`}`,
+		`{"value":{},"activation_id":"a2","action_name":"/ns/act","deadline":"4102444800000"}`,
+		`{"value":{},"activation_id":"a3"}`,
+	)
+	require.Equal(t, "{\"id\":\"a1\"}\n{\"id\":\"a2\"}\n{\"id\":\"a3\"}\n", out)
+	require.Equal(t, &Activation{Version: 1, ActivationID: "a1", Namespace: "ns", Deadline: 4102444800000, Headers: map[string]string{"x-id": "1"}}, seen[0])
+	require.Equal(t, &Activation{ActivationID: "a2", ActionName: "/ns/act", Deadline: 4102444800000}, seen[1])
+	require.Equal(t, &Activation{ActivationID: "a3"}, seen[2])
+	require.Equal(t, time.UnixMilli(4102444800000), deadlines[0])
+	require.Equal(t, time.UnixMilli(4102444800000), deadlines[1])
+	require.True(t, deadlines[2].IsZero())
+}
+
+func TestNewHandler(t *testing.T) {
+	for _, fn := range []interface{}{
+		func(map[string]interface{}) map[string]interface{} { return nil },
+		func([]interface{}) []interface{} { return nil },
+		func(context.Context, greetRequest) greetResponse { return greetResponse{} },
+		func(greetRequest) (interface{}, error) { return nil, nil },
+	} {
+		_, err := NewHandler(fn)
+		require.NoError(t, err)
+	}
+	for _, c := range []struct {
+		fn  interface{}
+		msg string
+	}{
+		{"main", "the action must be a function"},
+		{func() map[string]interface{} { return nil }, "the action must take the input, optionally after a context.Context"},
+		{func(context.Context, int, int) map[string]interface{} { return nil }, "the action must take the input, optionally after a context.Context"},
+		{func(chan int) map[string]interface{} { return nil }, "the action cannot take a chan int as input"},
+		{func(greetRequest) (greetResponse, int) { return greetResponse{}, 0 }, "the action must return the result, optionally followed by an error"},
+		{func(greetRequest) string { return "" }, "the action must return a map, a slice, an array or a struct, not a string"},
+	} {
+		_, err := NewHandler(c.fn)
+		require.EqualError(t, err, c.msg)
+	}
+}
+
+func ExampleNewHandler() {
+	handler, _ := NewHandler(greet)
+	res, err := handler(context.Background(), json.RawMessage(`{"name":"Mike"}`))
+	fmt.Println(res.(*greetResponse).Greeting, err)
+	_, err = handler(context.Background(), json.RawMessage(`{}`))
+	fmt.Println(err)
+	// Output:
+	// Hello, Mike <nil>
+	// missing name
+}
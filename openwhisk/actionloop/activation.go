@@ -0,0 +1,79 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package actionloop
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Activation is the context of the activation sent by the proxy, see the Action Loop Protocol
+type Activation struct {
+	Version       int               `json:"version"`
+	ActivationID  string            `json:"activation_id,omitempty"`
+	TransactionID string            `json:"transaction_id,omitempty"`
+	Namespace     string            `json:"namespace,omitempty"`
+	ActionName    string            `json:"action_name,omitempty"`
+	ActionVersion string            `json:"action_version,omitempty"`
+	APIHost       string            `json:"api_host,omitempty"`
+	Deadline      int64             `json:"deadline,omitempty"`
+	Traceparent   string            `json:"traceparent,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+// DeadlineTime returns the deadline of the activation, or the zero time if there is none
+func (a *Activation) DeadlineTime() time.Time {
+	if a.Deadline <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(a.Deadline)
+}
+
+type activationKey struct{}
+
+// FromContext returns the activation served with the context
+func FromContext(ctx context.Context) (*Activation, bool) {
+	a, ok := ctx.Value(activationKey{}).(*Activation)
+	return a, ok
+}
+
+// decodeActivation decodes the activation context of a request. Requests without it,
+// sent by older proxies, carry the same information in separate fields.
+func decodeActivation(input map[string]json.RawMessage) *Activation {
+	var a Activation
+	var s string
+	if json.Unmarshal(input["context"], &s) == nil && json.Unmarshal([]byte(s), &a) == nil {
+		return &a
+	}
+	str := func(key string) string {
+		var s string
+		json.Unmarshal(input[key], &s)
+		return s
+	}
+	a.ActivationID = str("activation_id")
+	a.TransactionID = str("transaction_id")
+	a.Namespace = str("namespace")
+	a.ActionName = str("action_name")
+	a.ActionVersion = str("action_version")
+	a.APIHost = str("api_host")
+	a.Deadline, _ = strconv.ParseInt(strings.Trim(string(input["deadline"]), `"`), 10, 64)
+	return &a
+}
@@ -0,0 +1,31 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package actionloop implements the ActionLoop protocol for actions written in Go,
+// so an executable only has to provide the function serving one activation:
+//
+//	func main() {
+//		actionloop.Serve(func(ctx context.Context, value json.RawMessage) (interface{}, error) {
+//			return map[string]interface{}{"hello": "world"}, nil
+//		})
+//	}
+//
+// Serve sends the acknowledgement when the runtime requires it, reads one request per line
+// from the standard input, decodes the activation context, cancels the context at the deadline
+// of the activation and writes the result, or the error as {"error": ...}, on file descriptor 3.
+// NewHandler adapts functions with typed input and output to a Handler.
+package actionloop
@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package actionloop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewHandler validates the signature of a function once, and returns the Handler invoking it.
+// The function can be:
+//
+//	func(in T) U
+//	func(in T) (U, error)
+//	func(ctx context.Context, in T) U
+//	func(ctx context.Context, in T) (U, error)
+//
+// where T is decoded from the JSON value of the request, and U is encoded as a JSON object or array,
+// so it must be a map, a slice, an array or a struct, or a pointer to one of them.
+func NewHandler(fn interface{}) (Handler, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, fmt.Errorf("the action must be a function")
+	}
+	t := v.Type()
+	withContext := t.NumIn() == 2 && t.In(0) == contextType
+	if t.NumIn() != 1 && !withContext {
+		return nil, fmt.Errorf("the action must take the input, optionally after a context.Context")
+	}
+	in := t.In(t.NumIn() - 1)
+	switch in.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, fmt.Errorf("the action cannot take a %s as input", in)
+	}
+	withError := t.NumOut() == 2 && t.Out(1) == errorType
+	if t.NumOut() != 1 && !withError {
+		return nil, fmt.Errorf("the action must return the result, optionally followed by an error")
+	}
+	out := t.Out(0)
+	kind := out.Kind()
+	if kind == reflect.Ptr {
+		kind = out.Elem().Kind()
+	}
+	switch kind {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Interface:
+	default:
+		return nil, fmt.Errorf("the action must return a map, a slice, an array or a struct, not a %s", out)
+	}
+
+	return func(ctx context.Context, value json.RawMessage) (interface{}, error) {
+		arg := reflect.New(in)
+		if len(value) > 0 {
+			if err := json.Unmarshal(value, arg.Interface()); err != nil {
+				return nil, fmt.Errorf("cannot decode the input: %v", err)
+			}
+		}
+		args := []reflect.Value{arg.Elem()}
+		if withContext {
+			args = []reflect.Value{reflect.ValueOf(&ctx).Elem(), arg.Elem()}
+		}
+		res := v.Call(args)
+		if withError && !res[1].IsNil() {
+			return nil, res[1].Interface().(error)
+		}
+		return res[0].Interface(), nil
+	}, nil
+}
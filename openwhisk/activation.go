@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ActivationContextKey is the key of the run request carrying the activation context to the action.
+// The context is a JSON document encoded as a string, so every launcher copying the string fields
+// of the request in __OW_<KEY> variables also exposes it as __OW_CONTEXT.
+const ActivationContextKey = "context"
+
+// ActivationContextVersion is the version of the schema of the activation context
+const ActivationContextVersion = 1
+
+// ActivationContext describes the current activation to the action, with typed fields
+type ActivationContext struct {
+	// Version of the schema, incremented only for incompatible changes
+	Version int `json:"version"`
+	// ActivationID is the id of the activation
+	ActivationID string `json:"activation_id,omitempty"`
+	// TransactionID is the id of the transaction the activation belongs to
+	TransactionID string `json:"transaction_id,omitempty"`
+	// Namespace of the action
+	Namespace string `json:"namespace,omitempty"`
+	// ActionName is the fully qualified name of the action
+	ActionName string `json:"action_name,omitempty"`
+	// ActionVersion is the version of the action
+	ActionVersion string `json:"action_version,omitempty"`
+	// APIHost is the host of the OpenWhisk API
+	APIHost string `json:"api_host,omitempty"`
+	// Deadline is when the activation times out, in milliseconds since the epoch
+	Deadline int64 `json:"deadline,omitempty"`
+	// Traceparent is the W3C trace context of the activation, so the action can continue the trace
+	Traceparent string `json:"traceparent,omitempty"`
+	// Headers are the extra headers of the run request, with lowercase names
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// headers of the run request that describe the transport and not the activation,
+// or that are credentials, not passed to the action; the traceparent has its own field
+var skippedHeaders = map[string]bool{
+	"Accept": true, "Accept-Encoding": true, "Authorization": true, "Connection": true,
+	"Content-Length": true, "Content-Type": true, "Cookie": true, "Host": true,
+	"Keep-Alive": true, "Proxy-Authorization": true, "Te": true, "Trailer": true,
+	"Transfer-Encoding": true, "Upgrade": true, "User-Agent": true, "Traceparent": true,
+}
+
+// newActivationContext builds the activation context from the fields of a run request and its headers
+func newActivationContext(fields map[string]json.RawMessage, header http.Header) ActivationContext {
+	ctx := ActivationContext{Version: ActivationContextVersion}
+	str := func(key string) string {
+		var s string
+		json.Unmarshal(fields[key], &s)
+		return s
+	}
+	ctx.ActivationID = str("activation_id")
+	ctx.TransactionID = str("transaction_id")
+	ctx.Namespace = str("namespace")
+	ctx.ActionName = str("action_name")
+	ctx.ActionVersion = str("action_version")
+	ctx.APIHost = str("api_host")
+	// the deadline is a number, but it is usually sent as a string
+	if err := json.Unmarshal(fields["deadline"], &ctx.Deadline); err != nil {
+		ctx.Deadline, _ = strconv.ParseInt(str("deadline"), 10, 64)
+	}
+	if tc, ok := ParseTraceparent(header.Get(TraceparentHeader)); ok {
+		ctx.Traceparent = tc.String()
+	}
+	for name, values := range header {
+		if skippedHeaders[name] || strings.HasPrefix(name, "Proxy-") {
+			continue
+		}
+		if ctx.Headers == nil {
+			ctx.Headers = map[string]string{}
+		}
+		ctx.Headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	return ctx
+}
+
+// encode the context as the JSON string sent to the action
+func (ctx ActivationContext) encode() string {
+	buf, _ := json.Marshal(ctx)
+	return string(buf)
+}
+
+// withActivationContext adds the activation context to the body of a run request, unless it is already there.
+// The rest of the body is left as it is; bodies that are not objects are not changed.
+func withActivationContext(body []byte, header http.Header) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return body
+	}
+	if _, ok := fields[ActivationContextKey]; ok {
+		return body
+	}
+	field, _ := json.Marshal(newActivationContext(fields, header).encode())
+	start := bytes.IndexByte(body, '{') + 1
+	var buf bytes.Buffer
+	buf.Grow(len(body) + len(field) + 16)
+	buf.Write(body[:start])
+	buf.WriteString(`"` + ActivationContextKey + `":`)
+	buf.Write(field)
+	if len(fields) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.Write(body[start:])
+	return buf.Bytes()
+}
+
+// withContextTraceparent sets the traceparent of an encoded activation context to the span running the action
+func withContextTraceparent(encoded string, s *span) string {
+	var ctx ActivationContext
+	if s == nil || json.Unmarshal([]byte(encoded), &ctx) != nil {
+		return encoded
+	}
+	ctx.Traceparent = s.traceparent()
+	return ctx.encode()
+}
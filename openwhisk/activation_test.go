@@ -0,0 +1,145 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func doContextRun(ts *httptest.Server, body string) {
+	req, _ := http.NewRequest("POST", ts.URL+"/run", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Basic secret")
+	req.Header.Set("X-Request-Id", "req-1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer res.Body.Close()
+	out, _ := io.ReadAll(res.Body)
+	fmt.Printf("%d %s", res.StatusCode, out)
+}
+
+func TestNewActivationContext(t *testing.T) {
+	var fields map[string]json.RawMessage
+	json.Unmarshal([]byte(`{"activation_id":"a1","namespace":"ns","action_name":"/ns/act","api_key":"k","deadline":"1700000000000"}`), &fields)
+	header := http.Header{}
+	header.Add("X-Trace", "a")
+	header.Add("X-Trace", "b")
+	header.Set("Cookie", "c")
+	header.Set("Proxy-Connection", "keep-alive")
+	ctx := newActivationContext(fields, header)
+	require.Equal(t, ActivationContext{
+		Version:      ActivationContextVersion,
+		ActivationID: "a1",
+		Namespace:    "ns",
+		ActionName:   "/ns/act",
+		Deadline:     1700000000000,
+		Headers:      map[string]string{"x-trace": "a, b"},
+	}, ctx)
+
+	json.Unmarshal([]byte(`{"deadline":1700000000001}`), &fields)
+	require.Equal(t, int64(1700000000001), newActivationContext(fields, nil).Deadline)
+	require.Equal(t, `{"version":1}`, newActivationContext(nil, nil).encode())
+}
+
+func TestWithActivationContext(t *testing.T) {
+	body := withActivationContext([]byte(`{"value":{"x":1},"activation_id":"a1"}`), nil)
+	require.Equal(t, `{"context":"{\"version\":1,\"activation_id\":\"a1\"}","value":{"x":1},"activation_id":"a1"}`, string(body))
+	require.Equal(t, `{"context":"{\"version\":1}"}`, string(withActivationContext([]byte(`{}`), nil)))
+	// an existing context and bodies that are not objects are left alone
+	require.Equal(t, `{"context":"mine"}`, string(withActivationContext([]byte(`{"context":"mine"}`), nil)))
+	require.Equal(t, `[1]`, string(withActivationContext([]byte(`[1]`), nil)))
+	require.Equal(t, `null`, string(withActivationContext([]byte(`null`), nil)))
+}
+
+func Example_activationContext() {
+	ts, cur, log := startTestServer("")
+	ts.Config.Handler.(*ActionProxy).config.ActivationContext = true
+	doInit(ts, initBinary("_test/context.sh", ""))
+	doContextRun(ts, `{"value":{},"activation_id":"a1","namespace":"ns","action_name":"/ns/act","api_key":"secret","deadline":"1700000000000"}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"version":1,"activation_id":"a1","namespace":"ns","action_name":"/ns/act","deadline":1700000000000,"headers":{"x-request-id":"req-1"}}
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_activationContextForwarded() {
+	clientLog, _ := os.CreateTemp("", "log")
+	clientConfig := testConfig("", "", ProxyModeClient)
+	clientConfig.ActivationContext = true
+	clientAP := NewActionProxy(clientConfig, clientLog, clientLog)
+	serverLog, _ := os.CreateTemp("", "log")
+	serverAP := NewActionProxy(testConfig("./action", "", ProxyModeServer), serverLog, serverLog)
+	server := httptest.NewServer(serverAP)
+	client := httptest.NewServer(clientAP)
+
+	doInit(client, initBinary("_test/context.sh", "@"+server.URL))
+	doContextRun(client, `{"value":{},"activation_id":"a1","namespace":"ns","action_name":"/ns/act","api_key":"secret","deadline":1700000000000}`)
+
+	client.Close()
+	server.Close()
+	os.Remove(serverLog.Name())
+	os.Remove(clientLog.Name())
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"version":1,"activation_id":"a1","namespace":"ns","action_name":"/ns/act","deadline":1700000000000,"headers":{"x-request-id":"req-1"}}
+}
+
+func Example_activationContextLoop() {
+	ts, cur, log := startTestServer("")
+	ts.Config.Handler.(*ActionProxy).config.ActivationContext = true
+	doInit(ts, initBinary("_test/loop", ""))
+	doContextRun(ts, `{"value":{"name":"Mike"},"activation_id":"a1"}`)
+	doContextRun(ts, `{"value":{}}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"hello":"Mike"}
+	// 200 {"error":"missing name"}
+	// activation=a1
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_activationContextDisabled() {
+	// by default the requests reach the action as they are, so a plain read does not mangle them
+	ts, cur, log := startTestServer("")
+	doInit(ts, `{"value":{"code":"#!/bin/sh\nwhile read line; do echo \"$line\" | jq -c '{context: has(\"context\")}' >&3; done\n"}}`)
+	doContextRun(ts, `{"value":{},"activation_id":"a1"}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"context":false}
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
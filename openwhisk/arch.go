@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// FatBinaryDir is the folder of the archives with executables for many platforms,
+// in subfolders <os>-<arch> with an exec each, like bin/linux-amd64/exec and bin/linux-arm64/exec
+const FatBinaryDir = "bin"
+
+// ArchitectureError is returned when the action is an executable for another platform
+type ArchitectureError struct {
+	msg string
+}
+
+func (e *ArchitectureError) Error() string {
+	return e.msg
+}
+
+// platform is the platform of the runtime, as <os>-<arch>
+func platform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// selectExecutable prepares the exec extracted in dir: in an archive without an exec in the top level
+// the exec for the platform of the runtime is taken from the FatBinaryDir, if any; then the exec,
+// when it is a Linux executable, must be for the architecture of the runtime
+func selectExecutable(dir string) error {
+	exec := filepath.Join(dir, "exec")
+	if _, err := os.Stat(exec); os.IsNotExist(err) {
+		if err := selectFatBinary(dir); err != nil {
+			return err
+		}
+	}
+	return checkArch(exec)
+}
+
+// selectFatBinary moves in the top level the exec for the platform of the runtime, when the archive has some
+func selectFatBinary(dir string) error {
+	fat := filepath.Join(dir, FatBinaryDir)
+	entries, err := os.ReadDir(fat)
+	if err != nil {
+		return nil
+	}
+	platforms := []string{}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(fat, entry.Name(), "exec")); entry.IsDir() && err == nil {
+			platforms = append(platforms, entry.Name())
+		}
+	}
+	if len(platforms) == 0 {
+		return nil
+	}
+	for _, p := range platforms {
+		if p == platform() {
+			Debug("selecting the executable for %s", p)
+			return os.Rename(filepath.Join(fat, p, "exec"), filepath.Join(dir, "exec"))
+		}
+	}
+	sort.Strings(platforms)
+	return &ArchitectureError{fmt.Sprintf("the action has executables for %s but not for %s",
+		strings.Join(platforms, ", "), platform())}
+}
+
+// checkArch checks a Linux executable is for the architecture of the runtime
+func checkArch(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	head := make([]byte, 64)
+	n, _ := io.ReadFull(f, head)
+	arch := ElfArch(head[:n])
+	if arch == "" || (runtime.GOOS == "linux" && arch == runtime.GOARCH) {
+		return nil
+	}
+	return &ArchitectureError{fmt.Sprintf("the action is an executable for linux-%s, but the runtime is %s", arch, platform())}
+}
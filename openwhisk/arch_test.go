@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// elfHeader returns the header of a Linux executable for the given target
+func elfHeader(machine elf.Machine, class elf.Class, data elf.Data) []byte {
+	buf := make([]byte, 64)
+	copy(buf, elf.ELFMAG)
+	buf[elf.EI_CLASS] = byte(class)
+	buf[elf.EI_DATA] = byte(data)
+	buf[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if data == elf.ELFDATA2MSB {
+		binary.BigEndian.PutUint16(buf[18:], uint16(machine))
+	} else {
+		binary.LittleEndian.PutUint16(buf[18:], uint16(machine))
+	}
+	return buf
+}
+
+// otherArch returns an architecture different from the one of the runtime, with its header
+func otherArch() (string, []byte) {
+	if runtime.GOARCH == "s390x" {
+		return "arm64", elfHeader(elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB)
+	}
+	return "s390x", elfHeader(elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB)
+}
+
+// fatZip returns an archive with the given executables under the FatBinaryDir
+func fatZip(execs map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for platform, exec := range execs {
+		header := &zip.FileHeader{Name: FatBinaryDir + "/" + platform + "/exec", Method: zip.Deflate}
+		header.SetMode(0755)
+		f, _ := w.CreateHeader(header)
+		f.Write(exec)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestElfArch(t *testing.T) {
+	hi, _ := os.ReadFile("_test/hi")
+	assert.Equal(t, runtime.GOARCH, ElfArch(hi))
+	assert.Equal(t, "amd64", ElfArch(elfHeader(elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB)))
+	assert.Equal(t, "arm64", ElfArch(elfHeader(elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB)))
+	assert.Equal(t, "386", ElfArch(elfHeader(elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB)))
+	assert.Equal(t, "arm", ElfArch(elfHeader(elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB)))
+	assert.Equal(t, "ppc64", ElfArch(elfHeader(elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB)))
+	assert.Equal(t, "ppc64le", ElfArch(elfHeader(elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB)))
+	assert.Equal(t, "s390x", ElfArch(elfHeader(elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB)))
+	assert.Equal(t, "riscv64", ElfArch(elfHeader(elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB)))
+	// the class matters: x32 executables are not amd64
+	assert.Equal(t, "EM_X86_64 ELFCLASS32 ELFDATA2LSB", ElfArch(elfHeader(elf.EM_X86_64, elf.ELFCLASS32, elf.ELFDATA2LSB)))
+	assert.Equal(t, "EM_SPARCV9 ELFCLASS64 ELFDATA2MSB", ElfArch(elfHeader(elf.EM_SPARCV9, elf.ELFCLASS64, elf.ELFDATA2MSB)))
+	assert.Equal(t, "", ElfArch(zipFile))
+	assert.Equal(t, "", ElfArch(shellFile))
+	assert.Equal(t, "", ElfArch(elfHeader(elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB)[:20]))
+}
+
+func TestExtractAction_arch(t *testing.T) {
+	assert.Nil(t, os.RemoveAll("./action/x8"))
+	ap := NewActionProxy(testConfig("./action/x8", "", ProxyModeNone), os.Stdout, os.Stderr)
+	arch, header := otherArch()
+
+	// an executable for the runtime
+	hi, _ := os.ReadFile("_test/hi")
+	_, err := ap.ExtractAction(&hi, "bin")
+	assert.NoError(t, err)
+
+	// an executable for another architecture is refused, and removed
+	_, err = ap.ExtractAction(&header, "bin")
+	var archErr *ArchitectureError
+	assert.ErrorAs(t, err, &archErr)
+	assert.EqualError(t, err, fmt.Sprintf("the action is an executable for linux-%s, but the runtime is %s", arch, platform()))
+	assert.Equal(t, 1, highestDir("./action/x8"))
+
+	// also in an archive
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.Create("exec")
+	f.Write(header)
+	w.Close()
+	zipped := buf.Bytes()
+	_, err = ap.ExtractAction(&zipped, "bin")
+	assert.ErrorAs(t, err, &archErr)
+}
+
+func TestExtractAction_fatBinary(t *testing.T) {
+	assert.Nil(t, os.RemoveAll("./action/x9"))
+	ap := NewActionProxy(testConfig("./action/x9", "", ProxyModeNone), os.Stdout, os.Stderr)
+	arch, header := otherArch()
+	hi, _ := os.ReadFile("_test/hi")
+
+	// the exec for the platform of the runtime is selected
+	fat := fatZip(map[string][]byte{platform(): hi, "linux-" + arch: header})
+	file, err := ap.ExtractAction(&fat, "bin")
+	assert.NoError(t, err)
+	exec, _ := os.ReadFile(file)
+	assert.Equal(t, hi, exec)
+	assert.Nil(t, exists("./action/x9", "bin/bin/linux-"+arch+"/exec"))
+
+	// there must be one
+	fat = fatZip(map[string][]byte{"linux-" + arch: header, "darwin-arm64": darwinFile})
+	_, err = ap.ExtractAction(&fat, "bin")
+	var archErr *ArchitectureError
+	assert.ErrorAs(t, err, &archErr)
+	assert.EqualError(t, err, fmt.Sprintf("the action has executables for darwin-arm64, linux-%s but not for %s", arch, platform()))
+}
+
+func TestInit_arch(t *testing.T) {
+	arch, header := otherArch()
+	loop, _ := os.ReadFile("_test/loop")
+	ts, cur, log := startTestServer("")
+	defer stopTestServer(ts, cur, log)
+
+	res, status, _ := doPost(ts.URL+"/init", initBytes(header, ""))
+	assert.Equal(t, 400, status)
+	assert.Equal(t, fmt.Sprintf(`{"error":"the action is an executable for linux-%s, but the runtime is %s"}`+"\n", arch, platform()), res)
+
+	fat := fatZip(map[string][]byte{platform(): loop, "linux-" + arch: header})
+	res, status, _ = doPost(ts.URL+"/init", initBytes(fat, ""))
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"ok":true}`+"\n", res)
+}
@@ -0,0 +1,132 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"io"
+)
+
+// ArchiveLimits bound the size of an action and what its archive can expand to,
+// so a zip bomb cannot fill the disk of the container. A zero value means no limit.
+type ArchiveLimits struct {
+	// Size is the maximum size of the action, compressed
+	Size int64
+	// Extracted is the maximum total size of the extracted files
+	Extracted int64
+	// Entries is the maximum number of entries in the archive
+	Entries int64
+	// File is the maximum size of a single extracted file
+	File int64
+}
+
+// ArchiveLimitError is returned when an action breaches one of the archive limits
+type ArchiveLimitError struct {
+	msg string
+}
+
+func (e *ArchiveLimitError) Error() string {
+	return e.msg
+}
+
+// extractBudget tracks what an extraction used of its limits; a nil budget has no limits
+type extractBudget struct {
+	limits    ArchiveLimits
+	entries   int64
+	extracted int64
+}
+
+// checkSize checks the size of the whole action
+func (b *extractBudget) checkSize(size int64) error {
+	if b != nil && b.limits.Size > 0 && size > b.limits.Size {
+		return &ArchiveLimitError{fmt.Sprintf("the action exceeds the size limit of %d bytes", b.limits.Size)}
+	}
+	return nil
+}
+
+// entry accounts for a new entry of the archive, with the size it declares
+func (b *extractBudget) entry(name string, size int64) error {
+	if b == nil {
+		return nil
+	}
+	b.entries++
+	if b.limits.Entries > 0 && b.entries > b.limits.Entries {
+		return &ArchiveLimitError{fmt.Sprintf("the action archive has more than %d entries", b.limits.Entries)}
+	}
+	return b.account(name, size, 0)
+}
+
+// account checks the size of a file after writing n more bytes of it
+func (b *extractBudget) account(name string, size int64, n int64) error {
+	if b == nil {
+		return nil
+	}
+	b.extracted += n
+	if b.limits.File > 0 && size > b.limits.File {
+		return &ArchiveLimitError{fmt.Sprintf("the file %s in the action archive exceeds the size limit of %d bytes", name, b.limits.File)}
+	}
+	if b.limits.Extracted > 0 && b.extracted > b.limits.Extracted {
+		return &ArchiveLimitError{fmt.Sprintf("the action archive expands to more than %d bytes", b.limits.Extracted)}
+	}
+	return nil
+}
+
+// copy extracts a file, stopping as soon as it breaches a limit:
+// the sizes declared in the archive headers cannot be trusted
+func (b *extractBudget) copy(dst io.Writer, src io.Reader, name string) error {
+	if b == nil {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	var size int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			size += int64(n)
+			if err := b.account(name, size, int64(n)); err != nil {
+				return err
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sizeReader fails when more than the size limit of the action is read
+type sizeReader struct {
+	r      io.Reader
+	read   int64
+	budget *extractBudget
+}
+
+func (s *sizeReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read += int64(n)
+	if err := s.budget.checkSize(s.read); err != nil {
+		return n, err
+	}
+	return n, err
+}
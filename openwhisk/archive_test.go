@@ -0,0 +1,144 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bombZip creates a zip with n files of size bytes, that compress very well
+func bombZip(n int, size int) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		w, _ := zw.Create(fmt.Sprintf("file%d", i))
+		w.Write(bytes.Repeat([]byte{'0'}, size))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+// bombTar creates a tar.gz with n files of size bytes
+func bombTar(n int, size int) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < n; i++ {
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file%d", i), Mode: 0644, Size: int64(size), Typeflag: tar.TypeReg})
+		tw.Write(bytes.Repeat([]byte{'0'}, size))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestLoadConfig_archiveLimits(t *testing.T) {
+	t.Setenv("OW_ARCHIVE_MAX_SIZE", "10m")
+	t.Setenv("OW_ARCHIVE_MAX_EXTRACTED", "1g")
+	t.Setenv("OW_ARCHIVE_MAX_ENTRIES", "1000")
+	t.Setenv("OW_ARCHIVE_MAX_FILE", "512k")
+	config, err := LoadConfig("", nil)
+	require.NoError(t, err)
+	require.Equal(t, ArchiveLimits{Size: 10 << 20, Extracted: 1 << 30, Entries: 1000, File: 512 << 10}, config.ArchiveLimits)
+
+	t.Setenv("OW_ARCHIVE_MAX_FILE", "lots")
+	_, err = LoadConfig("", nil)
+	require.EqualError(t, err, `invalid archive_max_file "lots" from the env OW_ARCHIVE_MAX_FILE`)
+	t.Setenv("OW_ARCHIVE_MAX_FILE", "-1")
+	_, err = LoadConfig("", nil)
+	require.EqualError(t, err, `invalid archive_max_file "-1" from the env OW_ARCHIVE_MAX_FILE`)
+}
+
+func TestExtractAction_archiveLimits(t *testing.T) {
+	cases := []struct {
+		env, val string
+		archive  []byte
+		msg      string
+	}{
+		{"OW_ARCHIVE_MAX_ENTRIES", "5", bombZip(6, 10), "more than 5 entries"},
+		{"OW_ARCHIVE_MAX_ENTRIES", "5", bombTar(6, 10), "more than 5 entries"},
+		{"OW_ARCHIVE_MAX_FILE", "1k", bombZip(1, 2000), "file0 in the action archive exceeds the size limit of 1024 bytes"},
+		{"OW_ARCHIVE_MAX_FILE", "1k", bombTar(1, 2000), "file0 in the action archive exceeds the size limit of 1024 bytes"},
+		{"OW_ARCHIVE_MAX_EXTRACTED", "1m", bombZip(3, 500000), "expands to more than 1048576 bytes"},
+		{"OW_ARCHIVE_MAX_EXTRACTED", "1m", bombTar(3, 500000), "expands to more than 1048576 bytes"},
+		{"OW_ARCHIVE_MAX_SIZE", "100", bombZip(2, 10), "exceeds the size limit of 100 bytes"},
+		{"OW_ARCHIVE_MAX_SIZE", "100", bombTar(2, 1000), "exceeds the size limit of 100 bytes"},
+		{"OW_ARCHIVE_MAX_SIZE", "100", []byte(strings.Repeat("x", 200)), "exceeds the size limit of 100 bytes"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		t.Setenv(c.env, c.val)
+		ap := NewActionProxy(testConfig(dir, "", ProxyModeNone), os.Stdout, os.Stderr)
+		// from memory and from a stream, spooled to disk if it is a zip
+		for _, src := range []io.Reader{bytes.NewReader(c.archive), io.MultiReader(bytes.NewReader(c.archive))} {
+			_, err := ap.ExtractActionFrom(src, "bin")
+			var limitErr *ArchiveLimitError
+			require.True(t, errors.As(err, &limitErr), "%s=%s: %v", c.env, c.val, err)
+			require.ErrorContains(t, err, c.msg)
+			// the partially extracted action is removed
+			require.NoDirExists(t, fmt.Sprintf("%s/%d", dir, ap.currentDir))
+		}
+		os.Unsetenv(c.env)
+	}
+
+	// within the limits
+	t.Setenv("OW_ARCHIVE_MAX_ENTRIES", "6")
+	t.Setenv("OW_ARCHIVE_MAX_FILE", "10")
+	t.Setenv("OW_ARCHIVE_MAX_EXTRACTED", "60")
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	_, err := ap.ExtractActionFrom(bytes.NewReader(bombZip(6, 10)), "bin")
+	require.NoError(t, err)
+	_, err = ap.ExtractActionFrom(bytes.NewReader(bombTar(6, 10)), "bin")
+	require.NoError(t, err)
+}
+
+func Example_initArchiveLimits() {
+	os.Setenv("OW_ARCHIVE_MAX_ENTRIES", "2")
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBytes(bombZip(3, 10), ""))
+	doInit(ts, initBinary("_test/hello.sh", ""))
+	stopTestServer(ts, cur, log)
+	os.Unsetenv("OW_ARCHIVE_MAX_ENTRIES")
+	// Output:
+	// 413 {"error":"the action archive has more than 2 entries"}
+	// 200 {"ok":true}
+}
+
+func Example_initBodyLimit() {
+	os.Setenv("OW_ARCHIVE_MAX_SIZE", "1k")
+	ts, cur, log := startTestServer("")
+	// the body can hold the code encoded in base64 and the other fields, not more
+	doInit(ts, `{"value":{"code":"#!/bin/sh\n","env":{"BIG":"`+strings.Repeat("x", 2<<20)+`"}}}`)
+	doInit(ts, initBinary("_test/hello.sh", ""))
+	stopTestServer(ts, cur, log)
+	os.Unsetenv("OW_ARCHIVE_MAX_SIZE")
+	// Output:
+	// 413 {"error":"the init request exceeds the size limit of 1049944 bytes"}
+	// 200 {"ok":true}
+}
@@ -0,0 +1,54 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// childEnabled tells if the program calls RunChild, so the proxy can start itself to run the actions
+var childEnabled atomic.Bool
+
+// errNoRunChild is the error of the actions needing the proxy to start itself, when the program does not allow it
+var errNoRunChild = errors.New("the program must call openwhisk.RunChild at the beginning of main")
+
+// RunChild runs the process started by the proxy executing itself for an action: with SandboxFlag it is the
+// launcher applying the sandbox, with WasmFlag it is the runtime of a WASM module. It exits when done, and
+// returns immediately in any other process. A program embedding the proxy must call it at the beginning
+// of its main, before parsing its flags, to run the sandboxed and the WASM actions.
+func RunChild() {
+	childEnabled.Store(true)
+	if len(os.Args) < 3 {
+		return
+	}
+	switch os.Args[1] {
+	case SandboxFlag:
+		err := RunSandbox(os.Args[2:])
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	case WasmFlag:
+		if err := RunWasm(os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunChild_disabled(t *testing.T) {
+	// the test binary calls RunChild in TestMain, as the proxy does in main
+	childEnabled.Store(false)
+	defer childEnabled.Store(true)
+
+	err := useLauncher(exec.Command("true"), []string{"nnp"})
+	assert.ErrorIs(t, err, errNoRunChild)
+
+	module, err := loopWasm()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(dir+"/1/bin", 0755))
+	code, err := os.ReadFile(module)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dir+"/1/bin/exec", code, 0755))
+	ap := NewActionProxy(testConfig(dir, "", ProxyModeNone), os.Stdout, os.Stderr)
+	_, err = ap.versionExecutor(1, ActionLimits{})
+	assert.ErrorIs(t, err, errNoRunChild)
+}
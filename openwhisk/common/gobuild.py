@@ -22,11 +22,27 @@ import os
 import re
 import sys
 import codecs
+import json
+import shutil
 import subprocess
 
-def sources(launcher, source_dir, main):
+# diagnostics of the compilation, for the proxy
+diagnostics = []
 
-    func = main.capitalize()
+# collect the messages of go build, as file:line:column: message
+def go_diagnostics(output):
+    for m in re.finditer(r"^(.+?\.go):(\d+):(?:(\d+):)? (.*)$", output, flags=re.MULTILINE):
+        diagnostics.append({"file": re.sub(r"^\./", "", m.group(1)), "line": int(m.group(2)),
+                            "column": int(m.group(3) or 0), "severity": "error", "message": m.group(4)})
+
+# write the diagnostics if the proxy asks for them
+def write_diagnostics():
+    file = os.environ.get("__OW_DIAGNOSTICS")
+    if file:
+        with open(file, "w") as f:
+            json.dump(diagnostics, f)
+
+def sources(launcher, source_dir, func):
     has_main = None
 
     # copy the exec to exec.go
@@ -50,15 +66,45 @@ def sources(launcher, source_dir, main):
                 code = e.read()
                 code = code.replace("Main", func)
                 d.write(code)
+    return not has_main
 
-def build(parent, source_dir, target):
-    # compile...
-    env = {
+# the launcher imports the actionloop package, copied in the GOPATH of the action
+def add_sdk(parent):
+    sdk = os.path.join(os.path.dirname(os.path.dirname(os.path.abspath(__file__))), "actionloop")
+    dst = os.path.join(parent, "src", "github.com", "apache", "openserverless-runtimes", "openwhisk", "actionloop")
+    shutil.copytree(sdk, dst, ignore=shutil.ignore_patterns("*_test.go"), dirs_exist_ok=True)
+
+# check the signature of the main function statically, with the proxy built for the tests
+def check(source_dir, func, target, env):
+    if os.path.isdir("%s/main" % source_dir):
+        source_dir += "/main"
+    proxy = os.path.join(os.path.dirname(os.path.dirname(os.path.abspath(__file__))), "_test", "proxy")
+    p = subprocess.run([proxy, "-check", source_dir, "-main", func], env=env,
+                       stdin=subprocess.DEVNULL, stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
+    if p.returncode != 0:
+        os.remove(target)
+        msg = p.stdout.decode('utf-8') or "invalid signature of the main function\n"
+        count = len(diagnostics)
+        go_diagnostics(msg)
+        if len(diagnostics) == count:
+            diagnostics.append({"severity": "error", "message": msg.strip()})
+        sys.stdout.write(msg)
+        sys.stdout.flush()
+        return False
+    return True
+
+# the environment of the go tools
+def go_env(parent):
+    return {
       "PATH": os.environ["PATH"],
       "GOPATH": os.path.abspath(parent),
       "GOCACHE": "/tmp",
       "GO111MODULE": "off"
     }
+
+def build(parent, source_dir, target):
+    # compile...
+    env = go_env(parent)
     if os.path.isdir("%s/main" % source_dir):
         source_dir += "/main"
     p = subprocess.Popen(
@@ -81,6 +127,7 @@ def build(parent, source_dir, target):
     o = re.sub(r"# .*\n", "", o, flags=re.MULTILINE)
     e = re.sub(r"# .*\n", "", e, flags=re.MULTILINE)
 
+    go_diagnostics(o + e)
     if o:
         sys.stdout.write(o)
         sys.stdout.flush()
@@ -88,6 +135,7 @@ def build(parent, source_dir, target):
     if e:
         sys.stderr.write(e)
         sys.stderr.flush()
+    return p.returncode == 0
 
 def main(argv):
     if len(argv) < 4:
@@ -101,8 +149,15 @@ def main(argv):
     parent = os.path.dirname(os.path.abspath(source_dir))
     target = os.path.abspath("%s/exec" % target_dir)
 
-    sources(argv[0]+".launcher.go", source_dir, main)
-    build(parent, source_dir, target)
+    func = main.capitalize()
+    with_launcher = sources(argv[0]+".launcher.go", source_dir, func)
+    if with_launcher:
+        add_sdk(parent)
+    ok = build(parent, source_dir, target)
+    if ok and with_launcher:
+        ok = check(source_dir, func, target, go_env(parent))
+    write_diagnostics()
+    sys.exit(0 if ok else 1)
 
 if __name__ == '__main__':
     main(sys.argv)
@@ -18,12 +18,27 @@
 package openwhisk
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// DefaultCompileTimeout is how long a compilation can last, unless changed with OW_COMPILE_TIMEOUT
+var DefaultCompileTimeout = 10 * time.Minute
+
+// ErrCompileTimeout is the error of a compilation killed because it lasted too long
+var ErrCompileTimeout = errors.New("compilation timed out")
+
 // check if the file exists and it is already compiled
 func isCompiled(file string) bool {
 	Debug("IsCompiled? %s", file)
@@ -42,28 +57,272 @@ func isCompiled(file string) bool {
 	return IsExecutable(buf, runtime.GOOS)
 }
 
-// CompileAction will compile an anction in source format invoking a compiler
+// DiagnosticsEnv is the variable with the path where the compiler can write its diagnostics,
+// as a JSON array of Diagnostic
+const DiagnosticsEnv = "__OW_DIAGNOSTICS"
+
+// Diagnostic is a message of the compiler, optionally about a location in the sources
+type Diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CompileError is a failed compilation, with the output of the compiler kept separated
+type CompileError struct {
+	Stdout      string
+	Stderr      string
+	ExitCode    int
+	Diagnostics []Diagnostic
+}
+
+func (e *CompileError) Error() string {
+	if out := e.Stdout + e.Stderr; out != "" {
+		if e.Stdout != "" && e.Stderr != "" && !strings.HasSuffix(e.Stdout, "\n") {
+			out = e.Stdout + "\n" + e.Stderr
+		}
+		return out
+	}
+	if len(e.Diagnostics) > 0 {
+		lines := make([]string, len(e.Diagnostics))
+		for i, d := range e.Diagnostics {
+			lines[i] = d.String()
+		}
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("the compiler failed with exit code %d", e.ExitCode)
+}
+
+// String formats the diagnostic like the compilers do, as file:line:column: message
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File + ":")
+		if d.Line > 0 {
+			fmt.Fprintf(&b, "%d:", d.Line)
+			if d.Column > 0 {
+				fmt.Fprintf(&b, "%d:", d.Column)
+			}
+		}
+		b.WriteString(" ")
+	}
+	if d.Severity != "" {
+		b.WriteString(d.Severity + ": ")
+	}
+	b.WriteString(d.Message)
+	return b.String()
+}
+
+// readDiagnostics reads the diagnostics written by the compiler, if any,
+// telling also if the compiler wrote them at all
+func readDiagnostics(file string) ([]Diagnostic, bool, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil || len(bytes.TrimSpace(buf)) == 0 {
+		return nil, false, nil
+	}
+	var diagnostics []Diagnostic
+	if err := json.Unmarshal(buf, &diagnostics); err != nil {
+		return nil, true, fmt.Errorf("invalid diagnostics of the compiler: %v", err)
+	}
+	return diagnostics, true, nil
+}
+
+// BuiltinCompilerPrefix selects a registered compiler, as in OW_COMPILER=builtin:go
+const BuiltinCompilerPrefix = "builtin:"
+
+// Compiler compiles the sources of an action in srcDir, producing the executable in binDir, usually binDir/exec.
+// The env is the environment of the action. A failed compilation should be a *CompileError, with the diagnostics.
+type Compiler interface {
+	Compile(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error)
+}
+
+// CompilerFunc is a function used as a Compiler
+type CompilerFunc func(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error)
+
+// Compile calls the function
+func (f CompilerFunc) Compile(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error) {
+	return f(ctx, main, srcDir, binDir, env)
+}
+
+// CompileResult is a successful compilation
+type CompileResult struct {
+	// Executable is the path of the executable
+	Executable string
+	// Output of the compiler, like warnings
+	Output string
+	// Diagnostics of the compiler, like warnings
+	Diagnostics []Diagnostic
+}
+
+var (
+	compilersMu sync.RWMutex
+	compilers   = map[string]Compiler{}
+)
+
+// RegisterCompiler makes a compiler available with the name builtin:<name>.
+// It panics registering twice the same name, so it is usually called in an init function.
+func RegisterCompiler(name string, compiler Compiler) {
+	compilersMu.Lock()
+	defer compilersMu.Unlock()
+	if compiler == nil {
+		panic("openwhisk: nil compiler " + name)
+	}
+	if _, dup := compilers[name]; dup {
+		panic("openwhisk: compiler registered twice " + name)
+	}
+	compilers[name] = compiler
+}
+
+// NewCompiler returns the compiler named as in OW_COMPILER: builtin:<name> is a registered compiler,
+// anything else is the path of a compiler script. There is no compiler for an empty name.
+func NewCompiler(name string) (Compiler, error) {
+	if name == "" {
+		return nil, nil
+	}
+	builtin, ok := strings.CutPrefix(name, BuiltinCompilerPrefix)
+	if !ok {
+		return &ScriptCompiler{Path: name}, nil
+	}
+	compilersMu.RLock()
+	defer compilersMu.RUnlock()
+	compiler, ok := compilers[builtin]
+	if !ok {
+		return nil, fmt.Errorf("unknown builtin compiler %s", builtin)
+	}
+	return compiler, nil
+}
+
+// SetCompiler sets the compiler of the actions, instead of the one named creating the proxy
+func (ap *ActionProxy) SetCompiler(compiler Compiler) {
+	ap.customCompiler = compiler
+}
+
+// hasCompiler tells if the actions in source format can be compiled
+func (ap *ActionProxy) hasCompiler() bool {
+	return ap.customCompiler != nil || ap.compiler != ""
+}
+
+// CompileAction will compile an anction in source format invoking a compiler.
+// It is CompileActionContext without a context to cancel the compilation.
 func (ap *ActionProxy) CompileAction(main string, srcDir string, binDir string) error {
-	if ap.compiler == "" {
+	return ap.CompileActionContext(context.Background(), main, srcDir, binDir)
+}
+
+// CompileActionContext will compile an anction in source format with the compiler of the proxy.
+// The compilation is cancelled when the context is done or when it lasts more than OW_COMPILE_TIMEOUT:
+// then the error is ErrCompileTimeout, or the error of the context.
+func (ap *ActionProxy) CompileActionContext(ctx context.Context, main string, srcDir string, binDir string) error {
+	compiler := ap.customCompiler
+	if compiler == nil {
+		var err error
+		if compiler, err = NewCompiler(ap.compiler); err != nil {
+			return err
+		}
+	}
+	if compiler == nil {
 		return fmt.Errorf("no compiler defined")
 	}
 
 	Debug("compiling: %s %s %s %s", ap.compiler, main, srcDir, binDir)
 
-	cmd := exec.Command(ap.compiler, main, srcDir, binDir)
-	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
-	for k, v := range ap.env {
-		cmd.Env = append(cmd.Env, k+"="+v)
+	timeout := ap.config.CompileTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrCompileTimeout)
+		defer cancel()
 	}
 
-	// gather stdout and stderr
-	out, err := cmd.CombinedOutput()
-	Debug("compiler out: %s, %v", out, err)
-	if len(out) > 0 {
-		return fmt.Errorf("%s", out)
+	result, err := compiler.Compile(ctx, main, srcDir, binDir, ap.env)
+	if err != nil && ctx.Err() != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, ErrCompileTimeout) {
+			return fmt.Errorf("%w after %v", ErrCompileTimeout, timeout)
+		}
+		return fmt.Errorf("compilation cancelled: %w", context.Cause(ctx))
 	}
 	if err != nil {
 		return err
 	}
+	Debug("compiled %s: %s %v", result.Executable, result.Output, result.Diagnostics)
 	return nil
 }
+
+// compilerCommand prepares a command of the compilation, killed with all its children when the context is done
+func compilerCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// run in its own process group, so we can kill also the children, like the go build or pip
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// do not wait forever the children escaping the process group and keeping the output open
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// runCompilerCommand runs a command of the compilation, gathering stdout and stderr
+func runCompilerCommand(cmd *exec.Cmd) (stdout, stderr bytes.Buffer, err error) {
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = startTracked(cmd)
+	if err == nil {
+		err = waitTracked(cmd)
+	}
+	Debug("compiler out: %s, err: %s, %v", stdout.Bytes(), stderr.Bytes(), err)
+	return stdout, stderr, err
+}
+
+// ScriptCompiler compiles invoking a script as <script> <main> <srcDir> <binDir>, that must produce binDir/exec.
+// The compilation fails if the script exits with an error, with or without the diagnostics, or if it does not
+// produce the executable. A script writing the diagnostics is trusted on its exit code, so its output, like warnings,
+// is not an error; older scripts instead report errors printing them with a zero exit code, so any output is an error.
+// The script, with all its children, is killed when the context is done.
+type ScriptCompiler struct {
+	// Path of the script
+	Path string
+}
+
+// Compile runs the script
+func (sc *ScriptCompiler) Compile(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error) {
+	diagnosticsFile, err := os.CreateTemp("", "diagnostics")
+	if err != nil {
+		return CompileResult{}, err
+	}
+	diagnosticsFile.Close()
+	defer os.Remove(diagnosticsFile.Name())
+
+	cmd := compilerCommand(ctx, sc.Path, main, srcDir, binDir)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), DiagnosticsEnv + "=" + diagnosticsFile.Name()}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdout, stderr, err := runCompilerCommand(cmd)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return CompileResult{}, err
+	}
+	diagnostics, written, derr := readDiagnostics(diagnosticsFile.Name())
+	if derr != nil {
+		return CompileResult{}, derr
+	}
+	compileErr := &CompileError{
+		Stdout:      stdout.String(),
+		Stderr:      stderr.String(),
+		Diagnostics: diagnostics,
+	}
+	// a failed compiler leaves no executable, even if it did not write the diagnostics
+	if exitErr != nil {
+		compileErr.ExitCode = exitErr.ExitCode()
+		os.Remove(filepath.Join(binDir, "exec"))
+		return CompileResult{}, compileErr
+	}
+	executable := filepath.Join(binDir, "exec")
+	if _, err := os.Stat(executable); err != nil {
+		return CompileResult{}, compileErr
+	}
+	if !written && stdout.Len()+stderr.Len() > 0 {
+		return CompileResult{}, compileErr
+	}
+	return CompileResult{Executable: executable, Output: stdout.String() + stderr.String(), Diagnostics: diagnostics}, nil
+}
@@ -18,8 +18,19 @@
 package openwhisk
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 /**
@@ -52,7 +63,7 @@ const (
 // compile a main
 func Example_cli_compiler() {
 	sys(PREP, "hello.src", "0", "exec")
-	ap := NewActionProxy(TMP, COMP, os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
 	fmt.Println(isCompiled(TMP + "0/src/exec"))
 	ap.CompileAction("main", TMP+"0/src", TMP+"0/bin")
 	sys(CHECK, TMP+"0/bin/exec")
@@ -72,7 +83,7 @@ func Example_cli_compiler() {
 func Example_hello() {
 	N := "1"
 	sys(PREP, "hello1.src", N, "exec")
-	ap := NewActionProxy(TMP, COMP, os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
 	env := map[string]interface{}{"GOROOT": TMP + N}
 	ap.SetEnv(env)
 	ap.CompileAction("hello", TMP+N+"/src", TMP+N+"/bin")
@@ -87,7 +98,7 @@ func Example_hello() {
 func Example_package() {
 	N := "2"
 	sys(PREP, "hello2.src", N, "exec", "hello")
-	ap := NewActionProxy(TMP, COMP, os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
 	env := map[string]interface{}{"GOROOT": TMP + N}
 	ap.SetEnv(env)
 	ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
@@ -102,7 +113,7 @@ func Example_package() {
 func Example_compileError() {
 	N := "6"
 	sys(PREP, "error.src", N)
-	ap := NewActionProxy(TMP, COMP, os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
 	err := ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
 	fmt.Printf("%v", removeLineNr(err.Error()))
 	// Unordered output:
@@ -112,7 +123,7 @@ func Example_compileError() {
 func Example_withMain() {
 	N := "7"
 	sys(PREP, "hi.src", N, "exec")
-	ap := NewActionProxy(TMP, COMP, os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
 	err := ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
 	fmt.Println(err)
 	sys(TMP + N + "/bin/exec")
@@ -120,3 +131,164 @@ func Example_withMain() {
 	// <nil>
 	// hi
 }
+
+func Example_compileDiagnostics() {
+	N := "8"
+	sys(PREP, "error.src", N)
+	ap := NewActionProxy(testConfig(TMP, COMP, ProxyModeNone), os.Stdout, os.Stderr)
+	err := ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		fmt.Println(compileErr.ExitCode)
+		for _, d := range compileErr.Diagnostics {
+			fmt.Println(d.File, d.Severity, d.Message)
+		}
+	}
+	// Output:
+	// 1
+	// exec__.go error syntax error: unexpected name error at end of statement
+}
+
+func TestCompileAction_warnings(t *testing.T) {
+	N := "9"
+	sys(PREP, "hello.sh", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, "_test/warncompile.sh", ProxyModeNone), os.Stdout, os.Stderr)
+	// the warning is not an error, as the compiler wrote the diagnostics
+	assert.Nil(t, ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin"))
+	assert.FileExists(t, TMP+N+"/bin/exec")
+}
+
+func TestCompileError(t *testing.T) {
+	err := &CompileError{ExitCode: 2}
+	assert.Equal(t, "the compiler failed with exit code 2", err.Error())
+	err.Diagnostics = []Diagnostic{{File: "main.go", Line: 3, Column: 1, Severity: "error", Message: "undefined: x"}, {Message: "failed"}}
+	assert.Equal(t, "main.go:3:1: error: undefined: x\nfailed", err.Error())
+	err.Stderr = "undefined: x\n"
+	assert.Equal(t, "undefined: x\n", err.Error())
+	err.Stdout = "failed"
+	assert.Equal(t, "failed\nundefined: x\n", err.Error())
+	err.Stdout = "failed\n"
+	assert.Equal(t, "failed\nundefined: x\n", err.Error())
+}
+
+func TestCompileAction_exitCode(t *testing.T) {
+	N := "20"
+	sys(PREP, "hello.sh", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, "_test/failcompile.sh", ProxyModeNone), os.Stdout, os.Stderr)
+	// the exit code fails the compilation also without diagnostics and output
+	err := ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
+	var compileErr *CompileError
+	if assert.ErrorAs(t, err, &compileErr) {
+		assert.Equal(t, 3, compileErr.ExitCode)
+		assert.Equal(t, "the compiler failed with exit code 3", err.Error())
+	}
+	assert.NoFileExists(t, TMP+N+"/bin/exec")
+}
+
+// compilerChildGone checks the child left by _test/slowcompile.sh was killed with the compiler
+func compilerChildGone(t *testing.T, binDir string) bool {
+	t.Helper()
+	buf, err := os.ReadFile(binDir + "/child.pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf)))
+	// the child is gone, or a zombie if nobody reaps it
+	state, _, err := procState(pid)
+	return err != nil || state == 'Z'
+}
+
+func TestCompileAction_timeout(t *testing.T) {
+	N := "10"
+	sys(PREP, "hello.sh", N, "exec")
+	t.Setenv("OW_COMPILE_TIMEOUT", "500ms")
+	ap := NewActionProxy(testConfig(TMP, "_test/slowcompile.sh", ProxyModeNone), os.Stdout, os.Stderr)
+	start := time.Now()
+	err := ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin")
+	assert.ErrorIs(t, err, ErrCompileTimeout)
+	assert.Equal(t, "compilation timed out after 500ms", err.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Eventually(t, func() bool { return compilerChildGone(t, TMP+N+"/bin") }, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestCompileActionContext_cancel(t *testing.T) {
+	N := "11"
+	sys(PREP, "hello.sh", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, "_test/slowcompile.sh", ProxyModeNone), os.Stdout, os.Stderr)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	err := ap.CompileActionContext(ctx, "main", TMP+N+"/src", TMP+N+"/bin")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrCompileTimeout)
+	assert.Eventually(t, func() bool { return compilerChildGone(t, TMP+N+"/bin") }, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestInit_compileClientDisconnect(t *testing.T) {
+	comp, _ := filepath.Abs("_test/slowcompile.sh")
+	dir := t.TempDir()
+	ap := NewActionProxy(testConfig(dir, comp, ProxyModeNone), os.Stdout, os.Stderr)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	// the client gives up before the compilation ends, so the compiler is killed
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+	_, err := client.Post(ts.URL+"/init", "application/json", strings.NewReader(initCode("_test/hello.src", "")))
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		pids, _ := filepath.Glob(dir + "/*/bin/child.pid")
+		return len(pids) > 0 && compilerChildGone(t, filepath.Dir(pids[0]))
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestNewCompiler(t *testing.T) {
+	compiler, err := NewCompiler("")
+	assert.Nil(t, compiler)
+	assert.NoError(t, err)
+	compiler, err = NewCompiler("/bin/compile")
+	assert.Equal(t, &ScriptCompiler{Path: "/bin/compile"}, compiler)
+	assert.NoError(t, err)
+	compiler, err = NewCompiler("builtin:go")
+	assert.Equal(t, GoCompiler{}, compiler)
+	assert.NoError(t, err)
+	_, err = NewCompiler("builtin:cobol")
+	assert.EqualError(t, err, "unknown builtin compiler cobol")
+}
+
+func TestRegisterCompiler(t *testing.T) {
+	echo := CompilerFunc(func(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error) {
+		return CompileResult{Executable: binDir + "/exec"}, nil
+	})
+	RegisterCompiler("test-echo", echo)
+	compiler, err := NewCompiler("builtin:test-echo")
+	assert.NoError(t, err)
+	result, err := compiler.Compile(context.Background(), "main", "src", "bin", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "bin/exec", result.Executable)
+	assert.Panics(t, func() { RegisterCompiler("test-echo", echo) })
+	assert.Panics(t, func() { RegisterCompiler("test-nil", nil) })
+}
+
+func TestSetCompiler(t *testing.T) {
+	var got map[string]string
+	// a compiler wrapping the source in a shell script
+	compiler := CompilerFunc(func(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error) {
+		got = env
+		src, err := os.ReadFile(srcDir + "/exec")
+		if err != nil {
+			return CompileResult{}, err
+		}
+		script := "#!/bin/sh\nwhile read line\ndo echo '" + strings.TrimSpace(string(src)) + "' >&3\ndone\n"
+		return CompileResult{Executable: binDir + "/exec"}, os.WriteFile(binDir+"/exec", []byte(script), 0755)
+	})
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	ap.SetCompiler(compiler)
+	ap.SetEnv(map[string]interface{}{"GREETING": "hello"})
+	file, err := ap.ExtractAndCompileFrom(strings.NewReader(`{"hello":"world"}`), "main")
+	assert.NoError(t, err)
+	assert.FileExists(t, file)
+	assert.Equal(t, "hello", got["GREETING"])
+	assert.NoError(t, ap.StartLatestAction())
+	defer ap.versions.stop()
+	out, err := ap.versions.executor().Interact([]byte("{}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hello":"world"}`, strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,486 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPort is the port where the proxy listens
+const DefaultPort = 8080
+
+// DefaultActionDir is the directory where the proxy stores the actions
+const DefaultActionDir = "./action"
+
+// Config is the configuration of the proxy. It is loaded from the defaults, overridden by the configuration file,
+// by the flags set in the command line and by the environment, in this order, and validated when loaded.
+type Config struct {
+	// Port is where the proxy listens
+	Port int
+	// ActionDir is the directory where the actions are stored
+	ActionDir string
+	// Compiler is the script compiling the actions, or builtin:go
+	Compiler string
+	// ProxyMode tells if the proxy runs the actions, forwards them as a client or runs them for a client as a server
+	ProxyMode ProxyMode
+	// SaveJar is the name of the uploaded jar, saved without extracting it
+	SaveJar string
+	// CodeDir is the directory of the code the actions can reference as local files, none when empty
+	CodeDir string
+	// WaitForAck enables waiting for the acknowledgment of the actions when they start
+	WaitForAck bool
+	// ExecutionEnv is the execution environment the actions must be compiled for
+	ExecutionEnv string
+	// ActivationContext sends the activation context to the actions, in the context key of the run requests
+	ActivationContext bool
+	// LogInitError logs the errors of the compilation instead of returning them
+	LogInitError bool
+	// CompileTimeout is how long a compilation can last, 0 for no limit
+	CompileTimeout time.Duration
+	// DeleteDuration is how long an action stopped by all its clients is kept in server mode
+	DeleteDuration time.Duration
+	// LogLevel is the lowest level of the logs
+	LogLevel string
+	// LogFormat is the format of the logs, text or json
+	LogFormat string
+	// StopSignal is sent to the process group of an action before killing it, 0 for none
+	StopSignal syscall.Signal
+	// StopGrace is how long to wait for an action to exit after the stop signal
+	StopGrace time.Duration
+	// KeepVersions is how many versions of the action are kept on disk, 0 for all of them
+	KeepVersions int
+	// ArchiveLimits bound the size of the actions and of what their archives expand to
+	ArchiveLimits ArchiveLimits
+	// SigningKeys are the public keys verifying the signed actions, as comma separated id=key; none accepts unsigned actions
+	SigningKeys string
+	// ActionUID is the user id, or the range of user ids, the actions run as
+	ActionUID string
+	// ActionGID is the group id, or the range of group ids, the actions run as; the user id when empty
+	ActionGID string
+	// ActionSandbox is the hardening of the actions, 1 for all of it or a comma separated list of nnp, seccomp and workdir
+	ActionSandbox string
+	// RecordFile is where the runs are recorded, none when empty
+	RecordFile string
+	// RecordMaxSize is the size of the recording file when it is rotated, 0 for never
+	RecordMaxSize int64
+	// RecordBackups is how many rotated recording files are kept
+	RecordBackups int
+	// RecordRedact are the comma separated patterns of the fields redacted in the recordings
+	RecordRedact string
+
+	// sources tells where each setting comes from
+	sources map[string]string
+}
+
+// setting describes a field of the configuration, with the name of its key in the configuration file,
+// also the name of its flag with dashes instead of underscores, and of its environment variables
+type setting struct {
+	key   string
+	env   []string
+	usage string
+	get   func(c *Config) interface{}
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"port", nil, "port where the proxy listens",
+		func(c *Config) interface{} { return c.Port },
+		func(c *Config, value string) (err error) { c.Port, err = strconv.Atoi(value); return }},
+	{"action_dir", nil, "directory where the actions are stored",
+		func(c *Config) interface{} { return c.ActionDir },
+		func(c *Config, value string) error { c.ActionDir = value; return nil }},
+	{"compiler", []string{"OW_COMPILER"}, "script compiling the actions, or builtin:go",
+		func(c *Config) interface{} { return c.Compiler },
+		func(c *Config, value string) error { c.Compiler = value; return nil }},
+	{"proxy_mode", []string{"OW_ACTIVATE_PROXY_CLIENT", "OW_ACTIVATE_PROXY_SERVER"}, "none, client to forward the actions, or server to run them for the clients",
+		func(c *Config) interface{} { return c.ProxyMode.String() },
+		func(c *Config, value string) (err error) { c.ProxyMode, err = parseProxyMode(value); return }},
+	{"save_jar", []string{"OW_SAVE_JAR"}, "name of the uploaded jar, saved without extracting it",
+		func(c *Config) interface{} { return c.SaveJar },
+		func(c *Config, value string) error { c.SaveJar = value; return nil }},
+	{"code_dir", []string{"OW_CODE_DIR"}, "directory of the code the actions can reference as local files, none when empty",
+		func(c *Config) interface{} { return c.CodeDir },
+		func(c *Config, value string) error { c.CodeDir = value; return nil }},
+	{"wait_for_ack", []string{"OW_WAIT_FOR_ACK"}, "wait for the acknowledgment of the actions when they start",
+		func(c *Config) interface{} { return c.WaitForAck },
+		func(c *Config, value string) (err error) { c.WaitForAck, err = strconv.ParseBool(value); return }},
+	{"execution_env", []string{"OW_EXECUTION_ENV"}, "execution environment the actions must be compiled for",
+		func(c *Config) interface{} { return c.ExecutionEnv },
+		func(c *Config, value string) error { c.ExecutionEnv = value; return nil }},
+	{"activation_context", []string{"OW_ACTIVATION_CONTEXT"}, "send the activation context to the actions, in the context key of the run requests",
+		func(c *Config) interface{} { return c.ActivationContext },
+		func(c *Config, value string) (err error) { c.ActivationContext, err = strconv.ParseBool(value); return }},
+	{"log_init_error", []string{"OW_LOG_INIT_ERROR"}, "log the errors of the compilation instead of returning them",
+		func(c *Config) interface{} { return c.LogInitError },
+		func(c *Config, value string) (err error) { c.LogInitError, err = strconv.ParseBool(value); return }},
+	{"compile_timeout", []string{"OW_COMPILE_TIMEOUT"}, "how long a compilation can last, 0 for no limit",
+		func(c *Config) interface{} { return c.CompileTimeout.String() },
+		func(c *Config, value string) (err error) { c.CompileTimeout, err = time.ParseDuration(value); return }},
+	{"delete_duration", []string{"OW_DELETE_DURATION"}, "how long an action stopped by all its clients is kept in server mode",
+		func(c *Config) interface{} { return c.DeleteDuration.String() },
+		func(c *Config, value string) (err error) { c.DeleteDuration, err = time.ParseDuration(value); return }},
+	{"log_level", []string{"OW_LOG_LEVEL"}, "lowest level of the logs: debug, info, warn or error",
+		func(c *Config) interface{} { return c.LogLevel },
+		func(c *Config, value string) error { c.LogLevel = strings.ToLower(value); return nil }},
+	{"log_format", []string{"OW_LOG_FORMAT"}, "format of the logs: text or json",
+		func(c *Config) interface{} { return c.LogFormat },
+		func(c *Config, value string) error { c.LogFormat = strings.ToLower(value); return nil }},
+	{"stop_signal", []string{"OW_STOP_SIGNAL"}, "signal sent to an action before killing it, as TERM or a number; none to kill it at once",
+		func(c *Config) interface{} { return signalName(c.StopSignal) },
+		func(c *Config, value string) (err error) { c.StopSignal, err = parseStopSignal(value); return }},
+	{"stop_grace", []string{"OW_STOP_GRACE"}, "how long to wait for an action to exit after the stop signal",
+		func(c *Config) interface{} { return c.StopGrace.String() },
+		func(c *Config, value string) (err error) { c.StopGrace, err = time.ParseDuration(value); return }},
+	{"keep_versions", []string{"OW_KEEP_VERSIONS"}, "how many versions of the action are kept on disk, 0 for all of them",
+		func(c *Config) interface{} { return c.KeepVersions },
+		func(c *Config, value string) (err error) { c.KeepVersions, err = strconv.Atoi(value); return }},
+	{"archive_max_size", []string{"OW_ARCHIVE_MAX_SIZE"}, "maximum size of an action before extracting it, as 10m; 0 for no limit",
+		func(c *Config) interface{} { return c.ArchiveLimits.Size },
+		func(c *Config, value string) (err error) { c.ArchiveLimits.Size, err = parseLimit(value); return }},
+	{"archive_max_extracted", []string{"OW_ARCHIVE_MAX_EXTRACTED"}, "maximum total size of the files extracted from an archive, 0 for no limit",
+		func(c *Config) interface{} { return c.ArchiveLimits.Extracted },
+		func(c *Config, value string) (err error) { c.ArchiveLimits.Extracted, err = parseLimit(value); return }},
+	{"archive_max_entries", []string{"OW_ARCHIVE_MAX_ENTRIES"}, "maximum number of entries of an archive, 0 for no limit",
+		func(c *Config) interface{} { return c.ArchiveLimits.Entries },
+		func(c *Config, value string) (err error) { c.ArchiveLimits.Entries, err = parseLimit(value); return }},
+	{"archive_max_file", []string{"OW_ARCHIVE_MAX_FILE"}, "maximum size of a file extracted from an archive, 0 for no limit",
+		func(c *Config) interface{} { return c.ArchiveLimits.File },
+		func(c *Config, value string) (err error) { c.ArchiveLimits.File, err = parseLimit(value); return }},
+	{"signing_keys", []string{"OW_SIGNING_KEYS"}, "ed25519 public keys of the signed actions, as comma separated id=key",
+		func(c *Config) interface{} { return c.SigningKeys },
+		func(c *Config, value string) error { c.SigningKeys = value; return nil }},
+	{"action_uid", []string{"OW_ACTION_UID"}, "user id, or range of user ids, the actions run as",
+		func(c *Config) interface{} { return c.ActionUID },
+		func(c *Config, value string) error { c.ActionUID = value; return nil }},
+	{"action_gid", []string{"OW_ACTION_GID"}, "group id, or range of group ids, the actions run as",
+		func(c *Config) interface{} { return c.ActionGID },
+		func(c *Config, value string) error { c.ActionGID = value; return nil }},
+	{"action_sandbox", []string{"OW_SANDBOX"}, "hardening of the actions: 1, or a comma separated list of nnp, seccomp and workdir",
+		func(c *Config) interface{} { return c.ActionSandbox },
+		func(c *Config, value string) error { c.ActionSandbox = value; return nil }},
+	{"record_file", []string{"OW_RECORD_FILE"}, "file where the runs are recorded, none when empty",
+		func(c *Config) interface{} { return c.RecordFile },
+		func(c *Config, value string) error { c.RecordFile = value; return nil }},
+	{"record_max_size", []string{"OW_RECORD_MAX_SIZE"}, "size in bytes when the recording file is rotated, 0 for never",
+		func(c *Config) interface{} { return c.RecordMaxSize },
+		func(c *Config, value string) (err error) {
+			c.RecordMaxSize, err = strconv.ParseInt(value, 10, 64)
+			return
+		}},
+	{"record_backups", []string{"OW_RECORD_BACKUPS"}, "how many rotated recording files are kept",
+		func(c *Config) interface{} { return c.RecordBackups },
+		func(c *Config, value string) (err error) { c.RecordBackups, err = strconv.Atoi(value); return }},
+	{"record_redact", []string{"OW_RECORD_REDACT"}, "comma separated patterns of the fields redacted in the recordings",
+		func(c *Config) interface{} { return c.RecordRedact },
+		func(c *Config, value string) error { c.RecordRedact = value; return nil }},
+}
+
+// DefaultConfig returns the default configuration; the logs are at the debug level when Debugging
+func DefaultConfig() *Config {
+	c := &Config{
+		Port:           DefaultPort,
+		ActionDir:      DefaultActionDir,
+		CompileTimeout: DefaultCompileTimeout,
+		DeleteDuration: timeToDeletion,
+		LogLevel:       "info",
+		LogFormat:      "text",
+		StopSignal:     DefaultStopSignal,
+		StopGrace:      DefaultStopGrace,
+		KeepVersions:   DefaultKeepVersions,
+		RecordMaxSize:  DefaultRecordMaxSize,
+		RecordBackups:  DefaultRecordBackups,
+		RecordRedact:   DefaultRecordRedact,
+		sources:        map[string]string{},
+	}
+	if Debugging {
+		c.LogLevel = "debug"
+	}
+	return c
+}
+
+// ConfigFlags defines the flags of the settings in the flag set,
+// returning the values set in the command line when it is parsed
+func ConfigFlags(fs *flag.FlagSet) map[string]string {
+	values := map[string]string{}
+	defaults := DefaultConfig()
+	for _, s := range settings {
+		fs.Var(&configFlag{s.key, values, fmt.Sprint(s.get(defaults))}, strings.ReplaceAll(s.key, "_", "-"), s.usage)
+	}
+	return values
+}
+
+// configFlag records the value of a setting set in the command line
+type configFlag struct {
+	key    string
+	values map[string]string
+	value  string
+}
+
+func (f *configFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *configFlag) Set(value string) error {
+	f.value = value
+	f.values[f.key] = value
+	return nil
+}
+
+// LoadConfig loads the configuration from the file, if any, the flags set in the command line and the environment,
+// failing if a setting is invalid
+func LoadConfig(file string, flags map[string]string) (*Config, error) {
+	c := DefaultConfig()
+	if file != "" {
+		values, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.apply(values, "file "+file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.apply(flags, "flag"); err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		value, name, err := lookupSetting(s)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+		if err := c.set(s.key, value, "env "+name); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// legacyEnabled are the boolean variables the proxy always enabled with any value, as false or 0 too
+var legacyEnabled = map[string]bool{"OW_WAIT_FOR_ACK": true, "OW_LOG_INIT_ERROR": true}
+
+// lookupSetting returns the value of a setting in the environment, with the variable setting it;
+// the boolean variables are enabled by any value but false or 0, except the legacy ones enabled by any value
+func lookupSetting(s setting) (string, string, error) {
+	if s.key == "proxy_mode" {
+		// the proxy mode is enabled only by 1, as it always was
+		client, server := os.Getenv("OW_ACTIVATE_PROXY_CLIENT") == "1", os.Getenv("OW_ACTIVATE_PROXY_SERVER") == "1"
+		switch {
+		case client && server:
+			return "", "", fmt.Errorf("OW_ACTIVATE_PROXY_CLIENT and OW_ACTIVATE_PROXY_SERVER cannot be both set")
+		case client:
+			return "client", "OW_ACTIVATE_PROXY_CLIENT", nil
+		case server:
+			return "server", "OW_ACTIVATE_PROXY_SERVER", nil
+		}
+		return "", "", nil
+	}
+	for _, name := range s.env {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if _, ok := s.get(&Config{}).(bool); ok {
+			value = strconv.FormatBool(legacyEnabled[name] || envEnabled(name))
+		}
+		return value, name, nil
+	}
+	return "", "", nil
+}
+
+// envEnabled tells if a boolean environment variable is set
+func envEnabled(name string) bool {
+	value := os.Getenv(name)
+	enabled, err := strconv.ParseBool(value)
+	return value != "" && (err != nil || enabled)
+}
+
+// apply sets the values of the settings, from the given source
+func (c *Config) apply(values map[string]string, source string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.set(key, values[key], source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set sets a setting, from the given source
+func (c *Config) set(key string, value string, source string) error {
+	for _, s := range settings {
+		if s.key == key {
+			if err := s.set(c, strings.TrimSpace(value)); err != nil {
+				return fmt.Errorf("invalid %s %q from the %s", key, value, source)
+			}
+			c.sources[key] = source
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %s from the %s", key, source)
+}
+
+// Validate checks the values of the settings
+func (c *Config) Validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d: it must be between 1 and 65535", c.Port)
+	}
+	if c.ActionDir == "" {
+		return fmt.Errorf("the action_dir cannot be empty")
+	}
+	if strings.ContainsRune(c.SaveJar, '/') {
+		return fmt.Errorf("invalid save_jar %q: it must be a file name", c.SaveJar)
+	}
+	if c.CodeDir != "" && !filepath.IsAbs(c.CodeDir) {
+		return fmt.Errorf("invalid code_dir %q: it must be an absolute path", c.CodeDir)
+	}
+	if c.CompileTimeout < 0 {
+		return fmt.Errorf("invalid compile_timeout %s: it cannot be negative", c.CompileTimeout)
+	}
+	if c.DeleteDuration < 0 {
+		return fmt.Errorf("invalid delete_duration %s: it cannot be negative", c.DeleteDuration)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("invalid log_level %q: use debug, info, warn or error", c.LogLevel)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("invalid log_format %q: use text or json", c.LogFormat)
+	}
+	if c.StopGrace < 0 {
+		return fmt.Errorf("invalid stop_grace %s: it cannot be negative", c.StopGrace)
+	}
+	if c.KeepVersions < 0 {
+		return fmt.Errorf("invalid keep_versions %d: it cannot be negative", c.KeepVersions)
+	}
+	if _, err := parseSigningKeys(c.SigningKeys); err != nil {
+		return err
+	}
+	if err := checkSandbox(c); err != nil {
+		return err
+	}
+	if c.RecordMaxSize < 0 {
+		return fmt.Errorf("invalid record_max_size %d: it cannot be negative", c.RecordMaxSize)
+	}
+	if c.RecordBackups < 0 {
+		return fmt.Errorf("invalid record_backups %d: it cannot be negative", c.RecordBackups)
+	}
+	if _, err := newRedactor(c.RecordRedact); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseLimit parses a size limit, as a number of bytes or with a k, m or g suffix
+func parseLimit(value string) (int64, error) {
+	n, err := parseSize(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit %q", value)
+	}
+	return int64(n), nil
+}
+
+// readConfigFile reads the settings of a TOML or YAML configuration file, by its extension
+func readConfigFile(file string) (map[string]string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the configuration: %w", err)
+	}
+	fields := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(buf, &fields)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(buf, &fields)
+	default:
+		return nil, fmt.Errorf("the configuration %s must be a .toml, .yaml or .yml file", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the configuration %s: %w", file, err)
+	}
+	values := map[string]string{}
+	for key, value := range fields {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("invalid %s in the configuration %s: it must be a value", key, file)
+		}
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// Print writes the effective settings as a TOML configuration file, commenting where each one comes from
+func (c *Config) Print(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, s := range settings {
+		line := fmt.Sprintf("%s = %s", s.key, tomlValue(s.get(c)))
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(&buf, "%-40s # %s\n", line, source)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// tomlValue encodes a value of a setting in TOML
+func tomlValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(value)
+}
+
+// parseProxyMode parses the name of a proxy mode
+func parseProxyMode(name string) (ProxyMode, error) {
+	switch strings.ToLower(name) {
+	case "none", "":
+		return ProxyModeNone, nil
+	case "client":
+		return ProxyModeClient, nil
+	case "server":
+		return ProxyModeServer, nil
+	}
+	return ProxyModeNone, fmt.Errorf("unknown proxy mode %s", name)
+}
@@ -0,0 +1,212 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearConfigEnv unsets the variables of the configuration until the end of the test
+func clearConfigEnv(t *testing.T) {
+	for _, s := range settings {
+		for _, name := range s.env {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+// configFile writes a configuration file in a temporary directory
+func configFile(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func TestLoadConfig_defaults(t *testing.T) {
+	clearConfigEnv(t)
+	config, err := LoadConfig("", nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPort, config.Port)
+	assert.Equal(t, DefaultActionDir, config.ActionDir)
+	assert.Equal(t, ProxyModeNone, config.ProxyMode)
+	assert.Equal(t, DefaultCompileTimeout, config.CompileTimeout)
+	assert.Equal(t, "info", config.LogLevel)
+	assert.False(t, config.WaitForAck)
+	assert.Equal(t, DefaultStopSignal, config.StopSignal)
+	assert.Equal(t, DefaultKeepVersions, config.KeepVersions)
+	assert.Equal(t, ArchiveLimits{}, config.ArchiveLimits)
+	assert.Equal(t, DefaultRecordRedact, config.RecordRedact)
+}
+
+func TestLoadConfig_precedence(t *testing.T) {
+	clearConfigEnv(t)
+	file := configFile(t, "proxy.toml", `
+port = 9090
+compiler = "/bin/compile"
+wait_for_ack = true
+compile_timeout = "1m"
+log_format = "json"
+`)
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	flags := ConfigFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-port", "9091", "-compile-timeout", "2m"}))
+	t.Setenv("OW_COMPILE_TIMEOUT", "3m")
+
+	// the file is overridden by the flags, overridden by the environment
+	config, err := LoadConfig(file, flags)
+	require.NoError(t, err)
+	assert.Equal(t, 9091, config.Port)
+	assert.Equal(t, "/bin/compile", config.Compiler)
+	assert.True(t, config.WaitForAck)
+	assert.Equal(t, 3*time.Minute, config.CompileTimeout)
+	assert.Equal(t, "json", config.LogFormat)
+
+	var buf bytes.Buffer
+	require.NoError(t, config.Print(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "port = 9091                              # flag\n")
+	assert.Contains(t, out, `compiler = "/bin/compile"                # file `+file+"\n")
+	assert.Contains(t, out, `compile_timeout = "3m0s"                 # env OW_COMPILE_TIMEOUT`+"\n")
+	assert.Contains(t, out, `log_level = "info"                       # default`+"\n")
+}
+
+func TestLoadConfig_yaml(t *testing.T) {
+	clearConfigEnv(t)
+	file := configFile(t, "proxy.yaml", "port: 8081\nproxy_mode: server\nsave_jar: exec.jar\n")
+	config, err := LoadConfig(file, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 8081, config.Port)
+	assert.Equal(t, ProxyModeServer, config.ProxyMode)
+	assert.Equal(t, "exec.jar", config.SaveJar)
+}
+
+func TestLoadConfig_env(t *testing.T) {
+	clearConfigEnv(t)
+	// any value but false enables a boolean variable, while the legacy ones are enabled by any value, as before
+	t.Setenv("OW_WAIT_FOR_ACK", "0")
+	t.Setenv("OW_LOG_INIT_ERROR", "false")
+	t.Setenv("OW_ACTIVATION_CONTEXT", "0")
+	t.Setenv("OW_ACTIVATE_PROXY_CLIENT", "1")
+	t.Setenv("OW_STOP_SIGNAL", "sigint")
+	t.Setenv("OW_STOP_GRACE", "3s")
+	t.Setenv("OW_KEEP_VERSIONS", "0")
+	t.Setenv("OW_RECORD_FILE", "/tmp/activations.jsonl")
+	t.Setenv("OW_RECORD_BACKUPS", "5")
+	config, err := LoadConfig("", nil)
+	require.NoError(t, err)
+	assert.True(t, config.WaitForAck)
+	assert.True(t, config.LogInitError)
+	assert.False(t, config.ActivationContext)
+	assert.Equal(t, ProxyModeClient, config.ProxyMode)
+	assert.Equal(t, syscall.SIGINT, config.StopSignal)
+	assert.Equal(t, 3*time.Second, config.StopGrace)
+	assert.Equal(t, 0, config.KeepVersions)
+	assert.Equal(t, "/tmp/activations.jsonl", config.RecordFile)
+	assert.Equal(t, 5, config.RecordBackups)
+
+	var buf bytes.Buffer
+	require.NoError(t, config.Print(&buf))
+	assert.Contains(t, buf.String(), `stop_signal = "INT"                      # env OW_STOP_SIGNAL`+"\n")
+	assert.Contains(t, buf.String(), `archive_max_size = 0                     # default`+"\n")
+
+	t.Setenv("OW_ACTIVATE_PROXY_SERVER", "1")
+	_, err = LoadConfig("", nil)
+	assert.EqualError(t, err, "OW_ACTIVATE_PROXY_CLIENT and OW_ACTIVATE_PROXY_SERVER cannot be both set")
+
+	// the proxy mode is enabled only by 1
+	t.Setenv("OW_ACTIVATE_PROXY_SERVER", "true")
+	config, err = LoadConfig("", nil)
+	require.NoError(t, err)
+	assert.Equal(t, ProxyModeClient, config.ProxyMode)
+}
+
+func TestLoadConfig_errors(t *testing.T) {
+	clearConfigEnv(t)
+	tests := []struct {
+		file  string
+		flags map[string]string
+		env   map[string]string
+		err   string
+	}{
+		{flags: map[string]string{"port": "http"}, err: `invalid port "http" from the flag`},
+		{flags: map[string]string{"port": "70000"}, err: "invalid port 70000: it must be between 1 and 65535"},
+		{flags: map[string]string{"action_dir": ""}, err: "the action_dir cannot be empty"},
+		{flags: map[string]string{"proxy_mode": "relay"}, err: `invalid proxy_mode "relay" from the flag`},
+		{env: map[string]string{"OW_COMPILE_TIMEOUT": "10"}, err: `invalid compile_timeout "10" from the env OW_COMPILE_TIMEOUT`},
+		{env: map[string]string{"OW_DELETE_DURATION": "-1s"}, err: "invalid delete_duration -1s: it cannot be negative"},
+		{env: map[string]string{"OW_SAVE_JAR": "lib/exec.jar"}, err: `invalid save_jar "lib/exec.jar": it must be a file name`},
+		{env: map[string]string{"OW_CODE_DIR": "code"}, err: `invalid code_dir "code": it must be an absolute path`},
+		{env: map[string]string{"OW_LOG_LEVEL": "verbose"}, err: `invalid log_level "verbose": use debug, info, warn or error`},
+		{env: map[string]string{"OW_LOG_FORMAT": "xml"}, err: `invalid log_format "xml": use text or json`},
+		{env: map[string]string{"OW_STOP_SIGNAL": "KILLALL"}, err: `invalid stop_signal "KILLALL" from the env OW_STOP_SIGNAL`},
+		{env: map[string]string{"OW_STOP_GRACE": "-1s"}, err: "invalid stop_grace -1s: it cannot be negative"},
+		{env: map[string]string{"OW_KEEP_VERSIONS": "-1"}, err: "invalid keep_versions -1: it cannot be negative"},
+		{env: map[string]string{"OW_ARCHIVE_MAX_SIZE": "big"}, err: `invalid archive_max_size "big" from the env OW_ARCHIVE_MAX_SIZE`},
+		{env: map[string]string{"OW_SIGNING_KEYS": "ci=bad"}, err: `invalid signing_keys: key "ci"`},
+		{env: map[string]string{"OW_SANDBOX": "chroot"}, err: `invalid action_sandbox option: "chroot"`},
+		{env: map[string]string{"OW_RECORD_MAX_SIZE": "big"}, err: `invalid record_max_size "big" from the env OW_RECORD_MAX_SIZE`},
+		{env: map[string]string{"OW_RECORD_BACKUPS": "-2"}, err: "invalid record_backups -2: it cannot be negative"},
+		{env: map[string]string{"OW_RECORD_REDACT": "("}, err: "invalid record_redact: "},
+		{file: configFile(t, "proxy.toml", "portt = 1\n"), err: "unknown setting portt from the file "},
+		{file: configFile(t, "proxy.toml", "[port]\nn = 1\n"), err: "invalid port in the configuration "},
+		{file: configFile(t, "proxy.toml", "port = \n"), err: "cannot parse the configuration "},
+		{file: configFile(t, "proxy.json", "{}"), err: "must be a .toml, .yaml or .yml file"},
+		{file: filepath.Join(t.TempDir(), "missing.yaml"), err: "cannot read the configuration: "},
+	}
+	for _, test := range tests {
+		for name, value := range test.env {
+			t.Setenv(name, value)
+		}
+		_, err := LoadConfig(test.file, test.flags)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), test.err)
+		}
+		for name := range test.env {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func TestNewActionProxy_config(t *testing.T) {
+	clearConfigEnv(t)
+	config, err := LoadConfig(configFile(t, "proxy.yml", "execution_env: exec/env\nwait_for_ack: true\n"), nil)
+	require.NoError(t, err)
+	config.ActionDir = t.TempDir()
+	ap := NewActionProxy(config, nil, nil)
+	ap.SetEnv(nil)
+	assert.Equal(t, "exec/env", ap.env["__OW_EXECUTION_ENV"])
+	assert.Equal(t, "1", ap.env["__OW_WAIT_FOR_ACK"])
+
+	// the value of the variable is passed as is
+	t.Setenv("OW_WAIT_FOR_ACK", "0")
+	config, err = LoadConfig("", nil)
+	require.NoError(t, err)
+	config.ActionDir = t.TempDir()
+	ap = NewActionProxy(config, nil, nil)
+	ap.SetEnv(nil)
+	assert.Equal(t, "0", ap.env["__OW_WAIT_FOR_ACK"])
+}
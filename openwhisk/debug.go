@@ -14,17 +14,55 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
+
 package openwhisk
 
-import "log"
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+)
 
-// Debugging flag
+// Debugging enables the behaviours to debug the actions: initializing them more than once,
+// keeping the actions that fail to start, resetting the proxy and debugging the actions.
+// It does not change the logs, whose verbosity is set by ConfigureLogging.
 var Debugging = false
 
+// Logger is the structured logger of the proxy, set up by ConfigureLogging.
+// The proxies add their own fields, so it must be set up before creating them.
+var Logger = newLogger(slog.LevelInfo, "text")
+
+// logWriter writes the logs where the standard logger writes, so they can be redirected in the same way
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	return log.Writer().Write(p)
+}
+
+// newLogger creates a logger with the given level and format, text or json
+func newLogger(level slog.Level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(logWriter{}, options))
+	}
+	return slog.New(slog.NewTextHandler(logWriter{}, options))
+}
+
+// ConfigureLogging sets up the Logger with the level and the format of the configuration
+func ConfigureLogging(config *Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return fmt.Errorf("invalid log_level %q: use debug, info, warn or error", config.LogLevel)
+	}
+	Logger = newLogger(level, config.LogFormat)
+	return nil
+}
+
 // Debug emits a debug message
 func Debug(format string, args ...interface{}) {
-	if Debugging {
-		log.Printf(format, args...)
+	if Logger.Enabled(context.Background(), slog.LevelDebug) {
+		Logger.Debug(fmt.Sprintf(format, args...))
 	}
 }
 
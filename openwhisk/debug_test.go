@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs sends the logs to a buffer until the end of the test, restoring the logger
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, debugging := Logger, Debugging
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(io.Discard)
+		Logger, Debugging = logger, debugging
+	})
+	return &buf
+}
+
+func TestConfigureLogging(t *testing.T) {
+	buf := captureLogs(t)
+	config := DefaultConfig()
+
+	// the debug logs are off by default
+	require.NoError(t, ConfigureLogging(config))
+	Debug("hidden %d", 1)
+	Logger.Info("shown", "n", 2)
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=INFO msg=shown n=2")
+
+	// debugging turns them on by default
+	buf.Reset()
+	Debugging = true
+	require.NoError(t, ConfigureLogging(DefaultConfig()))
+	Debug("shown %d", 1)
+	assert.Contains(t, buf.String(), `level=DEBUG msg="shown 1"`)
+	buf.Reset()
+	config.LogLevel = "warn"
+	require.NoError(t, ConfigureLogging(config))
+	Debug("hidden")
+	Logger.Info("hidden")
+	assert.Empty(t, buf.String())
+
+	// the json format has an object per line
+	config.LogLevel, config.LogFormat = "debug", "json"
+	require.NoError(t, ConfigureLogging(config))
+	DebugLimit("received", []byte("0123456789"), 4)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "received:0123...", record["msg"])
+
+	config.LogLevel = "verbose"
+	assert.EqualError(t, ConfigureLogging(config), `invalid log_level "verbose": use debug, info, warn or error`)
+}
+
+func TestActivationLogger(t *testing.T) {
+	buf := captureLogs(t)
+	config := DefaultConfig()
+	config.LogFormat = "json"
+	require.NoError(t, ConfigureLogging(config))
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeClient), nil, nil)
+
+	decode := func() map[string]interface{} {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		buf.Reset()
+		return record
+	}
+	ap.activationLogger([]byte(`{"value":{},"activation_id":"a1"}`)).Warn("exited")
+	record := decode()
+	assert.Equal(t, "client", record["mode"])
+	assert.Equal(t, "a1", record["activation_id"])
+
+	// the id in the activation context wins
+	ap.activationLogger([]byte(`{"activation_id":"a1","context":"{\"version\":1,\"activation_id\":\"a2\"}"}`)).Warn("exited")
+	assert.Equal(t, "a2", decode()["activation_id"])
+	ap.activationLogger([]byte(`[1]`)).Warn("exited")
+	assert.NotContains(t, decode(), "activation_id")
+}
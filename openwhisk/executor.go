@@ -25,6 +25,9 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -34,6 +37,13 @@ const OutputGuard = "XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX\n"
 // DefaultTimeoutStart to wait for a process to start
 var DefaultTimeoutStart = 5 * time.Millisecond
 
+// DefaultStopSignal is sent to the action process group before killing it,
+// so the action has a chance to flush its state
+var DefaultStopSignal = syscall.SIGTERM
+
+// DefaultStopGrace is how long to wait for the action to exit after the stop signal
+var DefaultStopGrace = 1 * time.Second
+
 // Executor is the container and the guardian  of a child process
 // It starts a command, feeds input and output, read logs and control its termination
 type Executor struct {
@@ -41,6 +51,19 @@ type Executor struct {
 	input  io.WriteCloser
 	output *bufio.Reader
 	exited chan bool
+	// limits of the process, applied when started
+	limits ActionLimits
+	cgroup *actionCgroup
+	// the cgroup open to start the process in it
+	cgroupDir *os.File
+	// state of the process after it exited
+	state *os.ProcessState
+	// hardening of the process and its private working directory
+	sandbox ActionSandbox
+	workDir string
+	// signal sent to the process group when stopped, 0 for none, and how long to wait before killing it
+	stopSignal syscall.Signal
+	stopGrace  time.Duration
 }
 
 // NewExecutor creates a child subprocess using the provided command line,
@@ -50,6 +73,8 @@ func NewExecutor(logout *os.File, logerr *os.File, command string, env map[strin
 	cmd := exec.Command(command, args...)
 	cmd.Stdout = logout
 	cmd.Stderr = logerr
+	// run in its own process group, so we can kill also the children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Env = []string{}
 	for k, v := range env {
 		cmd.Env = append(cmd.Env, k+"="+v)
@@ -69,43 +94,121 @@ func NewExecutor(logout *os.File, logerr *os.File, command string, env map[strin
 	cmd.ExtraFiles = []*os.File{pipeIn}
 	output := bufio.NewReader(pipeOut)
 	return &Executor{
-		cmd,
-		input,
-		output,
-		make(chan bool),
+		cmd:        cmd,
+		input:      input,
+		output:     output,
+		exited:     make(chan bool),
+		stopSignal: DefaultStopSignal,
+		stopGrace:  DefaultStopGrace,
 	}
 }
 
 // Interact interacts with the underlying process
+// If the action streams its response, the chunks are discarded and only the final result is returned
 func (proc *Executor) Interact(in []byte) ([]byte, error) {
+	return proc.InteractStream(in, nil)
+}
+
+// InteractStream interacts with the underlying process, passing to onChunk
+// the chunks the action streams before its final result, that is returned.
+// If onChunk fails, the following chunks are discarded.
+func (proc *Executor) InteractStream(in []byte, onChunk func([]byte) error) ([]byte, error) {
 	// input to the subprocess
 	proc.input.Write(in)
 	proc.input.Write([]byte("\n"))
 
+	// read lines until the final result
 	chout := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		out, err := proc.output.ReadBytes('\n')
-		if err == nil {
-			chout <- out
-		} else {
-			chout <- []byte{}
+		for {
+			out, err := proc.output.ReadBytes('\n')
+			if err != nil {
+				out = []byte{}
+			}
+			select {
+			case chout <- out:
+			case <-done:
+				return
+			}
+			if _, isChunk := parseChunk(out); !isChunk {
+				return
+			}
 		}
 	}()
+	// wall-clock limit of the run
+	var timeout <-chan time.Time
+	if proc.limits.Timeout > 0 {
+		timer := time.NewTimer(proc.limits.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// relay a chunk, returning false when the line is not a chunk
+	relay := func(line []byte) bool {
+		chunk, isChunk := parseChunk(line)
+		if !isChunk {
+			return false
+		}
+		if onChunk != nil && onChunk(chunk) != nil {
+			Debug("cannot send chunk, discarding the rest of the stream")
+			onChunk = nil
+		}
+		return true
+	}
 	var err error
 	var out []byte
-	select {
-	case out = <-chout:
-		if len(out) == 0 {
-			err = errors.New("no answer from the action")
+loop:
+	for {
+		select {
+		case out = <-chout:
+			if len(out) == 0 {
+				err = errors.New("no answer from the action")
+				break loop
+			}
+			if !relay(out) {
+				break loop
+			}
+			out = nil
+		case <-proc.exited:
+			// the chunks written just before exiting are still in the pipe
+			for drained := false; !drained; {
+				select {
+				case line := <-chout:
+					drained = !relay(line)
+				case <-time.After(100 * time.Millisecond):
+					drained = true
+				}
+			}
+			err = proc.exitError()
+			break loop
+		case <-timeout:
+			err = &LimitError{fmt.Sprintf("the action exceeded the time limit of %v", proc.limits.Timeout)}
+			break loop
 		}
-	case <-proc.exited:
-		err = errors.New("command exited")
 	}
 	proc.cmd.Stdout.Write([]byte(OutputGuard))
 	proc.cmd.Stderr.Write([]byte(OutputGuard))
 	return out, err
 }
 
+// exitError explains why the process exited, checking if it breached its limits
+func (proc *Executor) exitError() error {
+	if proc.cgroup != nil {
+		if err := proc.cgroup.breach(); err != nil {
+			return err
+		}
+	}
+	if proc.state != nil && proc.limits.CPUTime > 0 {
+		ws, ok := proc.state.Sys().(syscall.WaitStatus)
+		used := proc.state.UserTime() + proc.state.SystemTime()
+		if (ok && ws.Signaled() && ws.Signal() == syscall.SIGXCPU) || used >= proc.limits.CPUTime {
+			return &LimitError{fmt.Sprintf("the action exceeded the cpu time limit of %v", proc.limits.CPUTime)}
+		}
+	}
+	return errors.New("command exited")
+}
+
 // Exited checks if the underlying command exited
 func (proc *Executor) Exited() bool {
 	select {
@@ -128,17 +231,45 @@ type ActionAck struct {
 func (proc *Executor) Start(waitForAck bool) error {
 	// start the underlying executable
 	Debug("Start:")
-	err := proc.cmd.Start()
+	cmd := proc.cmd
+	if !proc.sandbox.IsZero() {
+		workDir, err := proc.sandbox.prepare(proc)
+		if err != nil {
+			proc.Stop()
+			return fmt.Errorf("cannot prepare the sandbox: %v", err)
+		}
+		proc.workDir = workDir
+	}
+	// the limits apply before the action runs
+	opts := proc.sandbox.launcherOptions()
+	if !proc.limits.IsZero() {
+		limitOpts, err := proc.prepareLimits()
+		if err != nil {
+			Debug("cannot apply limits: %v", err)
+			proc.Stop()
+			return err
+		}
+		opts = append(limitOpts, opts...)
+	}
+	if len(opts) > 0 {
+		if err := useLauncher(cmd, opts); err != nil {
+			proc.Stop()
+			return fmt.Errorf("cannot prepare the sandbox: %v", err)
+		}
+	}
+	err := startTracked(cmd)
+	proc.closeCgroupDir()
 	if err != nil {
 		Debug("run: early exit: %e", err)
-		proc.cmd = nil // no need to kill
+		proc.Stop()
 		return fmt.Errorf("command exited")
 	}
-	Debug("pid: %d", proc.cmd.Process.Pid)
+	Debug("pid: %d", cmd.Process.Pid)
 
 	go func() {
-		proc.cmd.Wait()
-		proc.exited <- true
+		waitTracked(cmd)
+		proc.state = cmd.ProcessState
+		close(proc.exited)
 	}()
 
 	// not waiting for an ack, so use a timeout
@@ -186,12 +317,110 @@ func (proc *Executor) Start(waitForAck bool) error {
 	}
 }
 
-// Stop will kill the process
-// and close the channels
+// Stop will send the stop signal to the process group,
+// wait for the grace period and then kill what is left
 func (proc *Executor) Stop() {
 	Debug("stopping")
-	if proc.cmd != nil {
-		proc.cmd.Process.Kill()
-		proc.cmd = nil
+	if proc.cmd == nil {
+		return
+	}
+	if proc.cmd.Process != nil {
+		pgid := proc.cmd.Process.Pid
+		sig, grace := proc.stopSignal, proc.stopGrace
+		if sig != 0 && grace > 0 && syscall.Kill(-pgid, sig) == nil {
+			Debug("sent %v to process group %d", sig, pgid)
+			select {
+			case <-proc.exited:
+			case <-time.After(grace):
+				Debug("process group %d still running after %v", pgid, grace)
+			}
+		}
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	// what was prepared is released also when the process did not start
+	proc.closeCgroupDir()
+	if proc.cgroup != nil {
+		cg := proc.cgroup
+		cg.kill()
+		go cg.remove()
+	}
+	proc.removeWorkDir()
+	proc.sandbox.release()
+	proc.cmd = nil
+}
+
+// closeCgroupDir closes the cgroup open to start the process, not needed once it started
+func (proc *Executor) closeCgroupDir() {
+	if proc.cgroupDir != nil {
+		proc.cgroupDir.Close()
+		proc.cgroupDir = nil
+	}
+}
+
+// removeWorkDir removes the private working directory of the action, if any
+func (proc *Executor) removeWorkDir() {
+	if proc.workDir != "" {
+		os.RemoveAll(proc.workDir)
+		proc.workDir = ""
+	}
+}
+
+// prepareLimits creates the cgroup of the process, if available, so it starts already in it,
+// and returns the rlimits the sandbox launcher sets before executing the action
+func (proc *Executor) prepareLimits() ([]string, error) {
+	if proc.limits.Memory > 0 || proc.limits.CPUs > 0 || proc.limits.Procs > 0 {
+		cg, err := newCgroup(proc.limits)
+		if err != nil {
+			Debug("no cgroup for the action, using rlimits only: %v", err)
+		} else {
+			proc.cgroup = cg
+			if proc.cgroupDir, err = os.Open(cg.dir); err == nil {
+				err = attachCgroup(proc.cmd.SysProcAttr, proc.cgroupDir)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot use the cgroup of the action: %v", err)
+			}
+		}
+	}
+	withUser := proc.sandbox.Credential != nil && proc.sandbox.Credential.Uid != 0
+	return rlimitOptions(proc.limits, proc.cgroup != nil, withUser), nil
+}
+
+// stopSignals are the signals an action can be stopped with, by name
+var stopSignals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseStopSignal parses the signal to send before killing the action, set with OW_STOP_SIGNAL:
+// a name like TERM or SIGINT or a number, while "0" or "none" disable it
+func parseStopSignal(value string) (syscall.Signal, error) {
+	name := strings.ToUpper(strings.TrimSpace(value))
+	if name == "NONE" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := stopSignals[strings.TrimPrefix(name, "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %s", value)
+}
+
+// signalName returns the name of a stop signal, as parsed by parseStopSignal
+func signalName(sig syscall.Signal) string {
+	if sig == 0 {
+		return "none"
+	}
+	for name, s := range stopSignals {
+		if s == sig {
+			return name
+		}
 	}
+	return strconv.Itoa(int(sig))
 }
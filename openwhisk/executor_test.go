@@ -19,6 +19,15 @@ package openwhisk
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var m = map[string]string{}
@@ -147,3 +156,89 @@ func ExampleNewExecutor_helloack() {
 	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
 	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
 }
+
+func writeScript(t *testing.T, body string) string {
+	file := filepath.Join(t.TempDir(), "exec")
+	require.NoError(t, os.WriteFile(file, []byte(body), 0755))
+	return file
+}
+
+// wait a bit for a process to be gone (or a zombie)
+func isGone(pid int) bool {
+	for i := 0; i < 20; i++ {
+		state, _, err := procState(pid)
+		if err != nil || state == 'Z' {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+func TestExecutorStop_processGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	script := writeScript(t, "#!/bin/sh\nsleep 60 &\necho $! >"+pidFile+"\nwhile read a; do echo '{}' >&3; done\n")
+	proc := NewExecutor(log, log, script, m)
+	require.NoError(t, proc.Start(false))
+	var pid int
+	require.Eventually(t, func() bool {
+		buf, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(buf)))
+		return err == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	proc.Stop()
+	assert.True(t, isGone(pid), "the child of the action should be killed")
+}
+
+func TestExecutorStop_softSignal(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	script := writeScript(t, "#!/bin/bash\ntrap 'echo flushed; exit 0' TERM\nwhile read a; do echo '{}' >&3; done\n")
+	proc := NewExecutor(log, log, script, m)
+	require.NoError(t, proc.Start(false))
+	res, err := proc.Interact([]byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(res))
+	proc.Stop()
+	out, _ := os.ReadFile(log.Name())
+	assert.Contains(t, string(out), "flushed")
+}
+
+func TestExecutorStop_noSoftSignal(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	script := writeScript(t, "#!/bin/bash\ntrap 'echo flushed; exit 0' TERM\nwhile read a; do echo '{}' >&3; done\n")
+	proc := NewExecutor(log, log, script, m)
+	proc.stopSignal = 0
+	require.NoError(t, proc.Start(false))
+	proc.Stop()
+	out, _ := os.ReadFile(log.Name())
+	assert.NotContains(t, string(out), "flushed")
+}
+
+func TestParseStopSignal(t *testing.T) {
+	for in, sig := range map[string]syscall.Signal{
+		"none":    0,
+		"0":       0,
+		"INT":     syscall.SIGINT,
+		"sigusr1": syscall.SIGUSR1,
+		"SIGQUIT": syscall.SIGQUIT,
+		"15":      syscall.SIGTERM,
+	} {
+		parsed, err := parseStopSignal(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, sig, parsed, in)
+	}
+	for _, in := range []string{"", "bogus", "-1"} {
+		_, err := parseStopSignal(in)
+		assert.Error(t, err, in)
+	}
+	assert.Equal(t, "TERM", signalName(syscall.SIGTERM))
+	assert.Equal(t, "none", signalName(0))
+	assert.Equal(t, "9", signalName(syscall.SIGKILL))
+}
@@ -18,7 +18,10 @@
 package openwhisk
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 )
@@ -50,23 +53,102 @@ func (ap *ActionProxy) ExtractAction(buf *[]byte, suffix string) (string, error)
 	if buf == nil || len(*buf) == 0 {
 		return "", fmt.Errorf("no file")
 	}
+	return ap.ExtractActionFrom(bytes.NewReader(*buf), suffix)
+}
+
+// ExtractActionFrom is like ExtractAction but streams the action from a reader straight to disk,
+// so the action is never entirely in memory. Tar.gz files are extracted while reading them,
+// while zip files are first copied to a temporary file, as they need random access.
+// The extraction is bounded by the ArchiveLimits; if it fails the action directory is removed.
+func (ap *ActionProxy) ExtractActionFrom(src io.Reader, suffix string) (file string, err error) {
+	budget := &extractBudget{limits: ap.config.ArchiveLimits}
+	sized := &sizeReader{r: src, budget: budget}
+	in := bufio.NewReaderSize(sized, 64*1024)
+	head, _ := in.Peek(4)
+	if len(head) == 0 {
+		return "", fmt.Errorf("no file")
+	}
 	ap.currentDir++
-	newDir := fmt.Sprintf("%s/%d/%s", ap.baseDir, ap.currentDir, suffix)
+	actionDir := fmt.Sprintf("%s/%d", ap.baseDir, ap.currentDir)
+	newDir := fmt.Sprintf("%s/%s", actionDir, suffix)
 	os.MkdirAll(newDir, 0755)
-	file := newDir + "/exec"
-	if IsZip(*buf) {
-		jar := os.Getenv("OW_SAVE_JAR")
+	defer func() {
+		if err != nil {
+			Debug("Extract Action failed, removing %s", actionDir)
+			os.RemoveAll(actionDir)
+		}
+	}()
+	file = newDir + "/exec"
+	if IsZip(head) {
+		archive, size, cleanup, err := readerAt(src, in)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		if err := budget.checkSize(size); err != nil {
+			return "", err
+		}
+		jar := ap.config.SaveJar
 		if jar != "" {
 			jarFile := newDir + "/" + jar
 			Debug("Extract Action, checking if it is a jar first")
-			return jarFile, UnzipOrSaveJar(*buf, newDir, jarFile)
+			return jarFile, unzipOrSaveJar(archive, size, newDir, jarFile, budget)
 		}
 		Debug("Extract Action, assuming a zip")
-		return file, Unzip(*buf, newDir)
-
-	} else if IsGz(*buf) {
+		if err := unzip(archive, size, newDir, budget); err != nil {
+			return "", err
+		}
+	} else if IsGz(head) {
 		Debug("Extract Action, assuming a tar.gz")
-		return file, UnTar(*buf, newDir)
+		if err := untar(in, newDir, budget); err != nil {
+			return "", err
+		}
+		// the end of the archive can be found before reading all the input
+		if err := budget.checkSize(sized.read); err != nil {
+			return "", err
+		}
+	} else if err := writeFile(file, in, 0755); err != nil {
+		return "", err
+	}
+	return file, selectExecutable(newDir)
+}
+
+// readerAt gives random access to a zip file: byte readers and files are used as they are,
+// anything else is copied to a temporary file, removed by the returned cleanup function
+func readerAt(src io.Reader, in io.Reader) (io.ReaderAt, int64, func(), error) {
+	switch s := src.(type) {
+	case *bytes.Reader:
+		return s, s.Size(), func() {}, nil
+	case *os.File:
+		if info, err := s.Stat(); err == nil && info.Mode().IsRegular() {
+			return s, info.Size(), func() {}, nil
+		}
+	}
+	tmp, err := os.CreateTemp("", "action-*.zip")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, in)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+// writeFile streams the reader to a file
+func writeFile(name string, in io.Reader, perm os.FileMode) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, in); err != nil {
+		file.Close()
+		return err
 	}
-	return file, os.WriteFile(file, *buf, 0755)
+	return file.Close()
 }
@@ -18,7 +18,11 @@
 package openwhisk
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"testing"
 
@@ -26,7 +30,7 @@ import (
 )
 
 func TestExtractActionTest_exec(t *testing.T) {
-	ap := NewActionProxy("./action/x1", "", os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x1", "", ProxyModeNone), os.Stdout, os.Stderr)
 	// cleanup
 	assert.Nil(t, os.RemoveAll("./action/x1"))
 	file, _ := os.ReadFile("_test/exec")
@@ -35,7 +39,7 @@ func TestExtractActionTest_exec(t *testing.T) {
 }
 
 func TestExtractActionTest_exe(t *testing.T) {
-	ap := NewActionProxy("./action/x2", "", os.Stdout, os.Stderr, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x2", "", ProxyModeNone), os.Stdout, os.Stderr)
 	// cleanup
 	assert.Nil(t, os.RemoveAll("./action/x2"))
 	// match  exe
@@ -46,7 +50,7 @@ func TestExtractActionTest_exe(t *testing.T) {
 
 func TestExtractActionTest_zip(t *testing.T) {
 	log, _ := os.CreateTemp("", "log")
-	ap := NewActionProxy("./action/x3", "", log, log, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x3", "", ProxyModeNone), log, log)
 	// cleanup
 	assert.Nil(t, os.RemoveAll("./action/x3"))
 	// match  exe
@@ -60,7 +64,7 @@ func TestExtractActionTest_zip(t *testing.T) {
 func TestExtractAction_script(t *testing.T) {
 	log, _ := os.CreateTemp("", "log")
 	assert.Nil(t, os.RemoveAll("./action/x4"))
-	ap := NewActionProxy("./action/x4", "", log, log, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x4", "", ProxyModeNone), log, log)
 	buf := []byte("#!/bin/sh\necho ok")
 	_, err := ap.ExtractAction(&buf, "bin")
 	//fmt.Print(err)
@@ -71,7 +75,7 @@ func TestExtractAction_save_jar(t *testing.T) {
 	os.Setenv("OW_SAVE_JAR", "exec.jar")
 	log, _ := os.CreateTemp("", "log")
 	assert.Nil(t, os.RemoveAll("./action/x5"))
-	ap := NewActionProxy("./action/x5", "", log, log, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x5", "", ProxyModeNone), log, log)
 	file, _ := os.ReadFile("_test/sample.jar")
 	_, err := ap.ExtractAction(&file, "bin")
 	assert.Nil(t, exists("./action/x5", "bin/exec.jar"))
@@ -83,7 +87,7 @@ func TestExtractAction_extract_jar(t *testing.T) {
 	os.Setenv("OW_SAVE_JAR", "")
 	log, _ := os.CreateTemp("", "log")
 	assert.Nil(t, os.RemoveAll("./action/x6"))
-	ap := NewActionProxy("./action/x6", "", log, log, ProxyModeNone)
+	ap := NewActionProxy(testConfig("./action/x6", "", ProxyModeNone), log, log)
 	file, _ := os.ReadFile("_test/sample.jar")
 	_, err := ap.ExtractAction(&file, "bin")
 	assert.Nil(t, exists("./action/x6", "bin/META-INF/MANIFEST.MF"))
@@ -119,3 +123,37 @@ func Example_badZip() {
 	// Output:
 	// true
 }
+
+func TestExtractActionFrom_stream(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	assert.Nil(t, os.RemoveAll("./action/x7"))
+	ap := NewActionProxy(testConfig("./action/x7", "", ProxyModeNone), log, log)
+	// a reader without random access, the zip is spooled to a temporary file
+	file, _ := os.ReadFile("_test/exec.zip")
+	_, err := ap.ExtractActionFrom(io.MultiReader(bytes.NewReader(file)), "bin")
+	assert.Nil(t, err)
+	assert.Equal(t, detectExecutable("./action/x7", "bin/exec"), true)
+	assert.Nil(t, exists("./action/x7", "bin/dir/etc"))
+
+	// a tar.gz is extracted while reading it
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	body := []byte("#!/bin/sh\necho ok\n")
+	tw.WriteHeader(&tar.Header{Name: "exec", Mode: 0755, Size: int64(len(body)), Typeflag: tar.TypeReg})
+	tw.Write(body)
+	tw.Close()
+	gz.Close()
+	_, err = ap.ExtractActionFrom(io.MultiReader(&buf), "bin")
+	assert.Nil(t, err)
+	assert.Equal(t, detectExecutable("./action/x7", "bin/exec"), true)
+
+	// plain files are copied
+	_, err = ap.ExtractActionFrom(io.MultiReader(bytes.NewReader(body)), "bin")
+	assert.Nil(t, err)
+	assert.Nil(t, exists("./action/x7", "bin/exec"))
+
+	// empty input
+	_, err = ap.ExtractActionFrom(io.MultiReader(), "bin")
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fetchClient downloads the code of the actions initialized by reference
+var fetchClient = &http.Client{Timeout: 10 * time.Minute}
+
+// parseDigest checks a SHA-256 digest in hex, optionally prefixed by "sha256:"
+func parseDigest(digest string) (string, error) {
+	digest = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(digest), "sha256:"))
+	if buf, err := hex.DecodeString(digest); err != nil || len(buf) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 digest %q", digest)
+	}
+	return digest, nil
+}
+
+// openCode opens the code referenced by an http(s) URL, or by a file URL or an absolute path in codeDir
+func openCode(ref string, codeDir string) (io.ReadCloser, int, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid code reference: %v", err)
+	}
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		res, err := fetchClient.Get(ref)
+		if err != nil {
+			return nil, http.StatusBadGateway, fmt.Errorf("cannot fetch the code: %v", err)
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, http.StatusBadGateway, fmt.Errorf("cannot fetch the code: %s", res.Status)
+		}
+		return res.Body, 0, nil
+	case u.Scheme == "file" || (u.Scheme == "" && filepath.IsAbs(ref)):
+		if codeDir == "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("unsupported code reference %q: local files are not enabled", ref)
+		}
+		path := ref
+		if u.Scheme == "file" {
+			path = u.Path
+		}
+		path, err := localCode(path, codeDir)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("cannot open the code: %v", err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("cannot open the code: %v", err)
+		}
+		return file, 0, nil
+	}
+	return nil, http.StatusBadRequest, fmt.Errorf("unsupported code reference %q", ref)
+}
+
+// localCode resolves the path of a local file, that must be in codeDir also following the symlinks
+func localCode(path string, codeDir string) (string, error) {
+	dir, err := filepath.EvalSymlinks(codeDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is not in the code directory", path)
+	}
+	return resolved, nil
+}
+
+// fetchCode streams the code referenced by the init request to a temporary file, verifying its digest;
+// local files are accepted only in codeDir, and the code cannot exceed maxSize bytes, if positive. It returns the file positioned at the beginning, that the caller must remove,
+// or the http status to answer with and the error.
+func fetchCode(ref string, digest string, codeDir string, maxSize int64) (*os.File, int, error) {
+	digest, err := parseDigest(digest)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	in, status, err := openCode(ref, codeDir)
+	if err != nil {
+		return nil, status, err
+	}
+	defer in.Close()
+	file, err := os.CreateTemp("", "code-")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	hash := sha256.New()
+	sized := &sizeReader{r: in, budget: &extractBudget{limits: ArchiveLimits{Size: maxSize}}}
+	if _, err = io.Copy(io.MultiWriter(file, hash), sized); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		var limitErr *ArchiveLimitError
+		if errors.As(err, &limitErr) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusBadGateway, fmt.Errorf("cannot fetch the code: %v", err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, http.StatusBadRequest, fmt.Errorf("sha256 mismatch: expected %s, got %s", digest, actual)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, http.StatusInternalServerError, err
+	}
+	Debug("fetched code from %s", ref)
+	return file, 0, nil
+}
@@ -0,0 +1,149 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Of(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func initRef(ref string, digest string) string {
+	j, _ := json.Marshal(initRequest{Value: initBodyRequest{URL: ref, SHA256: digest}})
+	return string(j)
+}
+
+// codeServer is a stand-in for a registry of action packages
+func codeServer(files map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf)
+	}))
+}
+
+func TestParseDigest(t *testing.T) {
+	sum := sha256Of([]byte("hello"))
+	digest, err := parseDigest(sum)
+	require.NoError(t, err)
+	require.Equal(t, sum, digest)
+	digest, err = parseDigest("sha256:" + sum)
+	require.NoError(t, err)
+	require.Equal(t, sum, digest)
+	_, err = parseDigest("")
+	require.Error(t, err)
+	_, err = parseDigest("sha256:1234")
+	require.Error(t, err)
+}
+
+func TestFetchCode(t *testing.T) {
+	code := []byte("#!/bin/sh\necho hello\n")
+	ts := codeServer(map[string][]byte{"/hello.sh": code})
+	defer ts.Close()
+
+	// from an url
+	file, _, err := fetchCode(ts.URL+"/hello.sh", sha256Of(code), "", 0)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(file)
+	file.Close()
+	os.Remove(file.Name())
+	require.Equal(t, code, buf)
+
+	// tampered code
+	_, status, err := fetchCode(ts.URL+"/hello.sh", sha256Of([]byte("other")), "", 0)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.ErrorContains(t, err, "sha256 mismatch")
+
+	// missing code
+	_, status, err = fetchCode(ts.URL+"/missing.sh", sha256Of(code), "", 0)
+	require.Equal(t, http.StatusBadGateway, status)
+	require.ErrorContains(t, err, "404")
+
+	// missing digest
+	_, status, err = fetchCode(ts.URL+"/hello.sh", "", "", 0)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Error(t, err)
+
+	// from a local file in the code directory, as a path or as an url
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello.sh")
+	os.WriteFile(path, code, 0644)
+	for _, ref := range []string{path, "file://" + path} {
+		file, _, err = fetchCode(ref, sha256Of(code), dir, 0)
+		require.NoError(t, err)
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	// local files are not accepted without a code directory, or out of it
+	_, status, err = fetchCode(path, sha256Of(code), "", 0)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.ErrorContains(t, err, "local files are not enabled")
+	_, _, err = fetchCode(path, sha256Of(code), t.TempDir(), 0)
+	require.ErrorContains(t, err, "is not in the code directory")
+	link := filepath.Join(dir, "passwd")
+	os.Symlink("/etc/passwd", link)
+	_, _, err = fetchCode(link, sha256Of(code), dir, 0)
+	require.ErrorContains(t, err, "is not in the code directory")
+
+	// the size limit of the action applies while fetching
+	_, status, err = fetchCode(ts.URL+"/hello.sh", sha256Of(code), "", 10)
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+	require.EqualError(t, err, "the action exceeds the size limit of 10 bytes")
+
+	// relative paths and other schemes are not supported
+	_, status, err = fetchCode("hello.sh", sha256Of(code), dir, 0)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.ErrorContains(t, err, "unsupported")
+	_, _, err = fetchCode("ftp://localhost/hello.sh", sha256Of(code), dir, 0)
+	require.ErrorContains(t, err, "unsupported")
+}
+
+func Example_initByReference() {
+	code, _ := os.ReadFile("_test/hello.sh")
+	cs := codeServer(map[string][]byte{"/hello.sh": code})
+	ts, cur, log := startTestServer("")
+	doInit(ts, initRef(cs.URL+"/hello.sh", sha256Of([]byte("tampered"))))
+	doInit(ts, initRef(cs.URL+"/hello.sh", sha256Of(code)))
+	doRun(ts, "")
+	stopTestServer(ts, cur, log)
+	cs.Close()
+	// Output:
+	// 400 {"error":"sha256 mismatch: expected d121be3103007b41edf96f8262925f8c7d61894afe9a041843b631f69445bc57, got 3cb02af1b14c90d6dfa8d688b6b64f3f943eea4d702bcba9dff47cfe702b82b9"}
+	// 200 {"ok":true}
+	// 200 {"hello": "Mike"}
+	// msg=hello Mike
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
@@ -17,6 +17,12 @@
 
 package openwhisk
 
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
 // IsElf checks for a Linux executable
 func IsElf(buf []byte) bool {
 	return len(buf) > 52 &&
@@ -24,6 +30,53 @@ func IsElf(buf []byte) bool {
 		buf[2] == 0x4C && buf[3] == 0x46
 }
 
+// elfTarget is the machine, the class and the byte order of an ELF executable
+type elfTarget struct {
+	machine elf.Machine
+	class   elf.Class
+	data    elf.Data
+}
+
+// elfArchs maps the targets of ELF executables to the Go architectures
+var elfArchs = map[elfTarget]string{
+	{elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB}:       "386",
+	{elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB}:    "amd64",
+	{elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB}:       "arm",
+	{elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB}:   "arm64",
+	{elf.EM_LOONGARCH, elf.ELFCLASS64, elf.ELFDATA2LSB}: "loong64",
+	{elf.EM_MIPS, elf.ELFCLASS32, elf.ELFDATA2MSB}:      "mips",
+	{elf.EM_MIPS, elf.ELFCLASS32, elf.ELFDATA2LSB}:      "mipsle",
+	{elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2MSB}:      "mips64",
+	{elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2LSB}:      "mips64le",
+	{elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB}:     "ppc64",
+	{elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB}:     "ppc64le",
+	{elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB}:     "riscv64",
+	{elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB}:      "s390x",
+}
+
+// ElfArch returns the architecture of a Linux executable, as GOARCH, from the machine, the class
+// and the byte order in its header; it is empty if it is not an executable, and it describes the header
+// for architectures unknown to Go
+func ElfArch(buf []byte) string {
+	if !IsElf(buf) {
+		return ""
+	}
+	class, data := elf.Class(buf[elf.EI_CLASS]), elf.Data(buf[elf.EI_DATA])
+	var machine elf.Machine
+	switch data {
+	case elf.ELFDATA2LSB:
+		machine = elf.Machine(binary.LittleEndian.Uint16(buf[18:]))
+	case elf.ELFDATA2MSB:
+		machine = elf.Machine(binary.BigEndian.Uint16(buf[18:]))
+	default:
+		return fmt.Sprintf("%s %s", class, data)
+	}
+	if arch, ok := elfArchs[elfTarget{machine, class, data}]; ok {
+		return arch
+	}
+	return fmt.Sprintf("%s %s %s", machine, class, data)
+}
+
 // IsExe checks for a Windows executable
 func IsExe(buf []byte) bool {
 	return len(buf) > 1 &&
@@ -43,9 +96,20 @@ func IsBangPath(buf []byte) bool {
 		buf[0] == '#' && buf[1] == '!'
 }
 
-// IsExecutable check if it is an executable, according the current runtime
+// IsWasm checks for a WebAssembly module
+func IsWasm(buf []byte) bool {
+	return len(buf) >= 8 &&
+		buf[0] == 0x00 && buf[1] == 0x61 && buf[2] == 0x73 && buf[3] == 0x6D &&
+		buf[4] == 0x01 && buf[5] == 0x00 && buf[6] == 0x00 && buf[7] == 0x00
+}
+
+// IsExecutable check if it is an executable, according the current runtime;
+// WebAssembly modules are executables for all the runtimes
 func IsExecutable(buf []byte, runtime string) bool {
 	Debug("checking executable for %s", runtime)
+	if IsWasm(buf) {
+		return true
+	}
 	switch runtime {
 	case "darwin":
 		return IsMach64(buf) || IsBangPath(buf)
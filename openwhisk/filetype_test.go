@@ -39,6 +39,10 @@ var windowsFile = []byte{
 
 var shellFile = []byte("#!/bin/sh\necho hello\n")
 
+var wasmFile = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x04, 0x01, 0x60, 0x00, 0x00, 0x03, 0x02,
+}
+
 func Example_filetype() {
 	fmt.Printf("%t\n%t\n", IsElf(linuxFile), IsElf(zipFile))
 	fmt.Printf("%t\n%t\n", IsMach64(darwinFile), IsMach64(zipFile))
@@ -48,6 +52,8 @@ func Example_filetype() {
 	fmt.Printf("%t\n%t\n", IsExecutable(windowsFile, "windows"), IsExecutable(zipFile, "windows"))
 	fmt.Printf("%t\n%t\n", IsExecutable(darwinFile, "darwin"), IsExecutable(zipFile, "darwin"))
 	fmt.Printf("%t\n%t\n%t\n", IsExecutable(shellFile, "darwin"), IsExecutable(shellFile, "linux"), IsExecutable(shellFile, "windows"))
+	fmt.Printf("%t\n%t\n", IsWasm(wasmFile), IsWasm(zipFile))
+	fmt.Printf("%t\n%t\n%t\n", IsExecutable(wasmFile, "darwin"), IsExecutable(wasmFile, "linux"), IsExecutable(wasmFile, "windows"))
 	// Output:
 	// true
 	// false
@@ -66,5 +72,10 @@ func Example_filetype() {
 	// true
 	// true
 	// false
+	// true
+	// false
+	// true
+	// true
+	// true
 
 }
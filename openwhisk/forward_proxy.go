@@ -40,15 +40,34 @@ func (ap *ActionProxy) ForwardRunRequest(w http.ResponseWriter, r *http.Request)
 		sendError(w, http.StatusInternalServerError, "Send init first")
 		return
 	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error reading run body while forwarding request: %v", err))
+		return
+	}
 	var runRequest runRequest
-	err := json.NewDecoder(r.Body).Decode(&runRequest)
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(body, &runRequest)
+	if err == nil {
+		err = json.Unmarshal(body, &fields)
+	}
 	if err != nil {
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding run body while forwarding request: %v", err))
 		return
 	}
 
+	// the hop to the server continues the trace of the run
+	_, span := ap.tracer.startSpan(r.Context(), "forward run", spanKindClient, r.Header)
+	defer span.end()
+	span.setAttribute("server.address", ap.clientProxyData.ProxyURL.Host)
+	header := withTraceparent(r.Header, span)
+
+	// the server only receives the value, so the activation context is built here
 	newBody := runRequest
 	newBody.ActionCodeHash = ap.clientProxyData.ActionCodeHash
+	if ap.config.ActivationContext && newBody.Context == "" {
+		newBody.Context = newActivationContext(fields, header).encode()
+	}
 
 	var buf bytes.Buffer
 	err = json.NewEncoder(&buf).Encode(newBody)
@@ -61,7 +80,7 @@ func (ap *ActionProxy) ForwardRunRequest(w http.ResponseWriter, r *http.Request)
 	r.Body = io.NopCloser(bytes.NewBuffer(buf.Bytes()))
 
 	director := func(req *http.Request) {
-		req.Header = r.Header.Clone()
+		req.Header = header.Clone()
 
 		// Reset content length with the new body
 		req.Header.Set("Content-Length", strconv.Itoa(bodyLen))
@@ -74,12 +93,29 @@ func (ap *ActionProxy) ForwardRunRequest(w http.ResponseWriter, r *http.Request)
 
 	proxy := &httputil.ReverseProxy{Director: director}
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		Debug("Error forwarding run request: %v", err)
+		span.setError(err)
+		ap.logger.Warn("cannot forward the run", "code_hash", ap.clientProxyData.ActionCodeHash, "error", err)
 		sendError(w, http.StatusBadGateway, "Error forwarding run request. Check logs for details.")
 	}
 
+	// relay the streamed responses as soon as they arrive
+	proxy.FlushInterval = -1
 	proxy.ModifyResponse = func(response *http.Response) error {
-		if response.StatusCode == http.StatusOK {
+		if response.StatusCode == http.StatusOK && response.Header.Get("Content-Type") == hopStreamContentType {
+			// The action is streaming, convert the hop stream in the format the caller accepts
+			body := response.Body
+			pr, pw := io.Pipe()
+			stream := newStreamWriter(pw, r.Header.Get("Accept"))
+			go func() {
+				defer body.Close()
+				pw.CloseWithError(relayHopStream(body, stream, ap.writeRemoteLogs))
+			}()
+			response.Body = pr
+			response.ContentLength = -1
+			response.Header.Del("Content-Length")
+			response.Header.Set("Content-Type", stream.contentType())
+			response.Header.Set("Cache-Control", "no-cache")
+		} else if response.StatusCode == http.StatusOK {
 			// Decode the response
 			var remoteReponse RemoteRunResponse
 			err := json.NewDecoder(response.Body).Decode(&remoteReponse)
@@ -89,22 +125,23 @@ func (ap *ActionProxy) ForwardRunRequest(w http.ResponseWriter, r *http.Request)
 			}
 
 			// Write the logs to the client logs.
-			if _, err := ap.outFile.WriteString(remoteReponse.Out); err != nil {
-				Debug("Error writing remote response out to client: %v", err)
-			}
-			// Avoid spamming just the output guard if there is no error string
-			if remoteReponse.Err != OutputGuard {
-				if _, err := ap.errFile.WriteString(remoteReponse.Err); err != nil {
-					Debug("Error writing remote response err to client: %v", err)
+			ap.writeRemoteLogs(&remoteReponse)
+
+			// Keep the response body only, unwrapping the envelope of non JSON results
+			result := []byte(remoteReponse.Response)
+			if contentType, raw, ok, err := parseResultEnvelope(result); ok {
+				if err != nil {
+					Debug("Error decoding remote result: %v", err)
+					return err
 				}
+				result = raw
+				response.Header.Set("Content-Type", contentType)
 			}
-
-			// Keep the response body only
-			response.Body = io.NopCloser(bytes.NewReader(remoteReponse.Response))
+			response.Body = io.NopCloser(bytes.NewReader(result))
 
 			// recalculate the content length
-			response.ContentLength = int64(len(remoteReponse.Response))
-			response.Header.Set("Content-Length", strconv.Itoa(len(remoteReponse.Response)))
+			response.ContentLength = int64(len(result))
+			response.Header.Set("Content-Length", strconv.Itoa(len(result)))
 		} else {
 			Debug("Remote response status code: %d", response.StatusCode)
 		}
@@ -119,6 +156,19 @@ func (ap *ActionProxy) ForwardRunRequest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// writeRemoteLogs writes the logs of a remote run to the client logs
+func (ap *ActionProxy) writeRemoteLogs(remoteReponse *RemoteRunResponse) {
+	if _, err := ap.outFile.WriteString(remoteReponse.Out); err != nil {
+		Debug("Error writing remote response out to client: %v", err)
+	}
+	// Avoid spamming just the output guard if there is no error string
+	if remoteReponse.Err != OutputGuard {
+		if _, err := ap.errFile.WriteString(remoteReponse.Err); err != nil {
+			Debug("Error writing remote response err to client: %v", err)
+		}
+	}
+}
+
 func (ap *ActionProxy) ForwardInitRequest(w http.ResponseWriter, r *http.Request) {
 	var initRequest initRequest
 	err := json.NewDecoder(r.Body).Decode(&initRequest)
@@ -142,7 +192,12 @@ func (ap *ActionProxy) ForwardInitRequest(w http.ResponseWriter, r *http.Request
 	newBody.Value.Main = ap.clientProxyData.MainFunc
 	newBody.ProxiedActionID = ap.clientProxyData.ProxyActionID
 
-	codeHash := calculateCodeHash(initRequest.Value.Code)
+	// actions initialized by reference are identified by the digest of the code
+	code := initRequest.Value.Code
+	if code == "" {
+		code = initRequest.Value.SHA256
+	}
+	codeHash := calculateCodeHash(code)
 	if newBody.Value.Env == nil {
 		newBody.Value.Env = make(map[string]interface{})
 	}
@@ -157,11 +212,17 @@ func (ap *ActionProxy) ForwardInitRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// the hop to the server continues the trace of the init
+	_, span := ap.tracer.startSpan(r.Context(), "forward init", spanKindClient, r.Header)
+	defer span.end()
+	span.setAttribute("server.address", ap.clientProxyData.ProxyURL.Host)
+	header := withTraceparent(r.Header, span)
+
 	bodyLen := buf.Len()
 	r.Body = io.NopCloser(bytes.NewBuffer(buf.Bytes()))
 
 	director := func(req *http.Request) {
-		req.Header = r.Header.Clone()
+		req.Header = header.Clone()
 
 		// Reset content length with the new body
 		req.Header.Set("Content-Length", strconv.Itoa(bodyLen))
@@ -174,7 +235,8 @@ func (ap *ActionProxy) ForwardInitRequest(w http.ResponseWriter, r *http.Request
 
 	proxy := &httputil.ReverseProxy{Director: director}
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		Debug("Error forwarding init request: %v", err)
+		span.setError(err)
+		ap.logger.Warn("cannot forward the init", "code_hash", ap.clientProxyData.ActionCodeHash, "error", err)
 		sendError(w, http.StatusBadGateway, "Error forwarding init request. Check logs for details.")
 	}
 
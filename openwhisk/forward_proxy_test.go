@@ -47,7 +47,7 @@ func TestCodeHashForwarded(t *testing.T) {
 	}))
 
 	// create a client ActionProxy
-	clientAP := NewActionProxy("", "", nil, nil, ProxyModeClient)
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), nil, nil)
 
 	// create a request body
 	body := initBinary("_test/hello.zip", "@"+ts.URL)
@@ -66,12 +66,12 @@ func TestCodeHashForwarded(t *testing.T) {
 
 func Example_forwardInitRequest() {
 	// create a client ActionProxy
-	clientAP := NewActionProxy("", "", nil, nil, ProxyModeClient)
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), nil, nil)
 
 	// create a server ActionProxy
 	compiler, _ := filepath.Abs("common/gobuild.py")
 	log, _ := os.CreateTemp("", "log")
-	serverAP := NewActionProxy("./action", compiler, log, log, ProxyModeServer)
+	serverAP := NewActionProxy(testConfig("./action", compiler, ProxyModeServer), log, log)
 
 	// start the server
 	ts := httptest.NewServer(serverAP)
@@ -102,11 +102,11 @@ func Example_forwardInitRequest() {
 func Example_forwardRunRequest() {
 	clientLog, _ := os.CreateTemp("", "log")
 	// create a client ActionProxy
-	clientAP := NewActionProxy("", "", clientLog, clientLog, ProxyModeClient)
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), clientLog, clientLog)
 
 	// create a server ActionProxy
 	compiler, _ := filepath.Abs("common/gobuild.py")
-	serverAP := NewActionProxy("./action", compiler, nil, nil, ProxyModeServer)
+	serverAP := NewActionProxy(testConfig("./action", compiler, ProxyModeServer), nil, nil)
 
 	// start the server
 	ts := httptest.NewServer(serverAP)
@@ -145,11 +145,11 @@ func Example_forwardRunRequest() {
 func Example_multipleForwardRunRequest() {
 	clientLog, _ := os.CreateTemp("", "log")
 	// create a client ActionProxy
-	clientAP := NewActionProxy("", "", clientLog, clientLog, ProxyModeClient)
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), clientLog, clientLog)
 
 	// create a server ActionProxy
 	compiler, _ := filepath.Abs("common/gobuild.py")
-	serverAP := NewActionProxy("./action", compiler, nil, nil, ProxyModeServer)
+	serverAP := NewActionProxy(testConfig("./action", compiler, ProxyModeServer), nil, nil)
 
 	// start the server
 	ts := httptest.NewServer(serverAP)
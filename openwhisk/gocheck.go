@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// CheckGoAction checks the signature of the function of a Go action, the one the launcher invokes, in the
+// package of the sources in a directory. The package is type checked from the sources with go/types, so the
+// action is never run: the compilers of the Go runtimes use it with -check once the action is built.
+// It returns a CompileError with the position of the function when the launcher would not accept it.
+func CheckGoAction(dir string, name string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return goCompileError([]Diagnostic{{Severity: "error", Message: err.Error()}})
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, src := range append(bp.GoFiles, bp.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, src), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+	return checkGoEntryType(fset, dir, files, name)
+}
+
+// checkGoEntryType type checks the files of the main package, and the signature of the function of the action.
+// The errors in the sources are left to the go tools, so the signature is not checked when there are any,
+// as its types may be the invalid ones of a missing import; the launcher still checks it at run time.
+func checkGoEntryType(fset *token.FileSet, srcDir string, files []*ast.File, name string) error {
+	config := types.Config{
+		Importer:    importer.ForCompiler(fset, "source", nil),
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	pkg, err := config.Check("main", fset, files, nil)
+	obj := pkg.Scope().Lookup(name)
+	if obj == nil {
+		return goCompileError([]Diagnostic{{Severity: "error", Message: fmt.Sprintf("cannot find the function %s of the action", name)}})
+	}
+	if err != nil {
+		Debug("cannot type check the action: %v", err)
+		return nil
+	}
+	if msg := checkGoSignature(obj.Type()); msg != "" {
+		return goCompileError([]Diagnostic{goDiagnostic(fset, srcDir, obj.Pos(), msg)})
+	}
+	return nil
+}
+
+// checkGoSignature checks the type of the function of the action as actionloop.NewHandler does at run time,
+// returning what is wrong with the same message
+func checkGoSignature(t types.Type) string {
+	qualifier := func(pkg *types.Package) string { return pkg.Name() }
+	sig, ok := t.Underlying().(*types.Signature)
+	if !ok {
+		return "the action must be a function"
+	}
+	if sig.TypeParams().Len() > 0 {
+		return "the action cannot be a generic function"
+	}
+	params := sig.Params()
+	withContext := params.Len() == 2 && isGoNamed(params.At(0).Type(), "context", "Context")
+	if params.Len() != 1 && !withContext {
+		return "the action must take the input, optionally after a context.Context"
+	}
+	in := params.At(params.Len() - 1).Type()
+	switch u := in.Underlying().(type) {
+	case *types.Chan, *types.Signature:
+		return fmt.Sprintf("the action cannot take a %s as input", types.TypeString(in, qualifier))
+	case *types.Basic:
+		if u.Info()&types.IsComplex != 0 || u.Kind() == types.UnsafePointer {
+			return fmt.Sprintf("the action cannot take a %s as input", types.TypeString(in, qualifier))
+		}
+	}
+	results := sig.Results()
+	withError := results.Len() == 2 && types.Identical(results.At(1).Type(), types.Universe.Lookup("error").Type())
+	if results.Len() != 1 && !withError {
+		return "the action must return the result, optionally followed by an error"
+	}
+	out := results.At(0).Type()
+	u := out.Underlying()
+	if ptr, ok := u.(*types.Pointer); ok {
+		u = ptr.Elem().Underlying()
+	}
+	switch u.(type) {
+	case *types.Map, *types.Slice, *types.Array, *types.Struct, *types.Interface:
+		return ""
+	}
+	return fmt.Sprintf("the action must return a map, a slice, an array or a struct, not a %s", types.TypeString(out, qualifier))
+}
+
+// isGoNamed tells if a type is the named type of a package
+func isGoNamed(t types.Type, pkg string, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == pkg && named.Obj().Name() == name
+}
@@ -0,0 +1,59 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckGoAction(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"func Main(in map[string]interface{}) map[string]interface{} { return in }", ""},
+		{"func Main(ctx context.Context, in T) (*U, error) { return nil, nil }", ""},
+		{"func Main(ctx context.Context, in T) ([]U, error) { return nil, nil }", ""},
+		{"var Main = func(in T) U { return U{} }", ""},
+		{"func Main(in T) string { return \"\" }", "exec__.go:6:6: the action must return a map, a slice, an array or a struct, not a string\n"},
+		{"func Main(in T) *int { return nil }", "exec__.go:6:6: the action must return a map, a slice, an array or a struct, not a *int\n"},
+		{"func Main(in chan T) U { return U{} }", "exec__.go:6:6: the action cannot take a chan main.T as input\n"},
+		{"func Main(in complex64) U { return U{} }", "exec__.go:6:6: the action cannot take a complex64 as input\n"},
+		{"func Main(ctx T, in T) U { return U{} }", "exec__.go:6:6: the action must take the input, optionally after a context.Context\n"},
+		{"func Main(in T) (U, int) { return U{}, 0 }", "exec__.go:6:6: the action must return the result, optionally followed by an error\n"},
+		{"func Main(c ctx.Context, in T) U { return U{} }", ""},
+		{"func Main[V any](in V) U { return U{} }", "exec__.go:6:6: the action cannot be a generic function\n"},
+		{"var Main = 1", "exec__.go:6:5: the action must be a function\n"},
+		{"func Hello(in T) U { return U{} }", "cannot find the function Main of the action\n"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		source := "package main\nimport \"context\"\nvar _ context.Context\ntype T struct{}\ntype U struct{}\n" + test.source + "\n"
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "exec__.go"), []byte(source), 0644))
+		err := CheckGoAction(dir, "Main")
+		if test.err == "" {
+			assert.NoError(t, err, test.source)
+		} else if assert.Error(t, err, test.source) {
+			assert.Equal(t, test.err, err.Error(), test.source)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// goLauncher is the main of the Go actions, invoking the function of the action with the ActionLoop protocol.
+// It imports the actionloop package, added to the module of the action from the sources in goSDK.
+var goLauncher = template.Must(template.New("main__.go").Parse(`package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/apache/openserverless-runtimes/openwhisk/actionloop"
+)
+
+// OwExecutionEnv is the execution environment set at compile time
+var OwExecutionEnv = ""
+
+func main() {
+	handler, err := actionloop.NewHandler({{.Function}})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// check if the execution environment is correct
+	if OwExecutionEnv != "" && OwExecutionEnv != os.Getenv("__OW_EXECUTION_ENV") {
+		fmt.Println("Execution Environment Mismatch")
+		fmt.Println("Expected: ", OwExecutionEnv)
+		fmt.Println("Actual: ", os.Getenv("__OW_EXECUTION_ENV"))
+		os.Exit(1)
+	}
+
+	if err := actionloop.Serve(handler); err != nil {
+		log.Fatal(err)
+	}
+}
+`))
+
+// goSDK are the sources of the actionloop package, so the actions can be built without downloading it
+//
+//go:embed actionloop/*.go
+var goSDK embed.FS
+
+// goModule is the module of the actionloop package, replaced in the actions by goSDKDir in their sources
+const (
+	goModule = "github.com/apache/openserverless-runtimes"
+	goSDKDir = "_sdk"
+)
+
+// goBuildError matches the errors of the go tools, as file:line:column: message
+var goBuildError = regexp.MustCompile(`(?m)^(.+?\.go):(\d+):(?:(\d+):)? (.*)$`)
+
+func init() {
+	RegisterCompiler("go", GoCompiler{})
+}
+
+// GoCompiler builds Go actions, as the bin/compile script of the Go runtimes does; it is registered as builtin:go.
+// The sources are parsed before building them, to find the function of the action and to check its signature:
+// if there is a main function in the main package, the action is built as it is, otherwise the launcher is added.
+type GoCompiler struct{}
+
+// Compile builds the action with the go tools
+func (GoCompiler) Compile(ctx context.Context, main string, srcDir string, binDir string, env map[string]string) (CompileResult, error) {
+	target, output, err := compileGo(ctx, main, srcDir, binDir, env)
+	if err != nil {
+		return CompileResult{}, err
+	}
+	return CompileResult{Executable: target, Output: output}, nil
+}
+
+// compileGo builds the action, returning the executable and the output of the build
+func compileGo(ctx context.Context, main string, srcDir string, binDir string, actionEnv map[string]string) (string, string, error) {
+	srcDir, err := filepath.Abs(srcDir)
+	if err != nil {
+		return "", "", err
+	}
+	binDir, err = filepath.Abs(binDir)
+	if err != nil {
+		return "", "", err
+	}
+	target := filepath.Join(binDir, "exec")
+
+	// a single file action is in the exec file
+	if info, err := os.Stat(filepath.Join(srcDir, "exec")); err == nil && info.Mode().IsRegular() {
+		if err := os.Rename(filepath.Join(srcDir, "exec"), filepath.Join(srcDir, "exec__.go")); err != nil {
+			return "", "", err
+		}
+	}
+	pkg := "."
+	if info, err := os.Stat(filepath.Join(srcDir, "main")); err == nil && info.IsDir() {
+		pkg = "./main"
+	}
+
+	fset := token.NewFileSet()
+	files, diagnostics := parseGoSources(fset, srcDir, filepath.Join(srcDir, pkg))
+	if len(diagnostics) > 0 {
+		return "", "", goCompileError(diagnostics)
+	}
+	var entry *ast.FuncDecl
+	if !hasGoMain(files) {
+		if entry = findGoEntry(files, main); entry == nil {
+			return "", "", goCompileError([]Diagnostic{{Severity: "error", Message: fmt.Sprintf("cannot find the function %s of the action", goEntryNames(main)[0])}})
+		}
+	}
+
+	env := goEnv(actionEnv)
+	if _, err := os.Stat(filepath.Join(srcDir, "go.mod")); err == nil {
+		if _, err := runGoCommand(ctx, srcDir, env, "cannot download modules", "go", "mod", "download"); err != nil {
+			return "", "", err
+		}
+	} else if _, err := runGoCommand(ctx, srcDir, env, "cannot init modules", "go", "mod", "init", "exec"); err != nil {
+		return "", "", err
+	}
+
+	// the signature is type checked with the modules of the action, before adding the launcher
+	if entry != nil {
+		if err := checkGoEntryType(fset, srcDir, files, entry.Name.Name); err != nil {
+			return "", "", err
+		}
+		if err := writeGoLauncher(filepath.Join(srcDir, pkg, "main__.go"), entry.Name.Name); err != nil {
+			return "", "", err
+		}
+		if err := writeGoSDK(filepath.Join(srcDir, goSDKDir)); err != nil {
+			return "", "", err
+		}
+		if _, err := runGoCommand(ctx, srcDir, env, "cannot add the actionloop package", "go", "mod", "edit",
+			"-require="+goModule+"@v0.0.0", "-replace="+goModule+"=./"+goSDKDir); err != nil {
+			return "", "", err
+		}
+	}
+
+	// if the debug port is present and not empty build with debug
+	execEnv := actionEnv["__OW_EXECUTION_ENV"]
+	if port := actionEnv["__OW_DEBUG_PORT"]; port != "" {
+		return target, "", debugGo(srcDir, binDir, port, execEnv)
+	}
+
+	ldflags := "-s -w"
+	if execEnv != "" {
+		ldflags += " -X main.OwExecutionEnv=" + execEnv
+		if err := os.WriteFile(target+".env", []byte(execEnv), 0644); err != nil {
+			return "", "", err
+		}
+	}
+	output, err := runGoCommand(ctx, srcDir, env, "", "go", "build", "-o", target, "-ldflags", ldflags, pkg)
+	return target, output, err
+}
+
+// writeGoLauncher writes the launcher invoking the function of the action
+func writeGoLauncher(file string, function string) error {
+	var buf bytes.Buffer
+	if err := goLauncher.Execute(&buf, struct{ Function string }{function}); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// writeGoSDK writes the actionloop package in a module of its own, as the Go runtimes ship it
+func writeGoSDK(dir string) error {
+	pkgDir := filepath.Join(dir, "openwhisk", "actionloop")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+goModule+"\n\ngo 1.20\n"), 0644); err != nil {
+		return err
+	}
+	entries, err := goSDK.ReadDir("actionloop")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		data, err := goSDK.ReadFile("actionloop/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(pkgDir, entry.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goEnvVars are the variables of the proxy passed to the go tools, the ones the compile scripts set
+var goEnvVars = []string{"PATH", "HOME", "GOROOT", "GOPATH", "GOCACHE"}
+
+// goEnv is the environment of the go tools: the one of the proxy for the go tools, and the one of the action
+func goEnv(actionEnv map[string]string) []string {
+	env := []string{"GO111MODULE=on"}
+	for _, key := range goEnvVars {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	// the go tools need a cache, and the user running the proxy may not have a home
+	if os.Getenv("GOCACHE") == "" && os.Getenv("HOME") == "" {
+		env = append(env, "GOCACHE=/tmp")
+	}
+	for k, v := range actionEnv {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// runGoCommand runs a go command in the sources, returning its output, or a CompileError with the diagnostics
+// found in it; when the message is not empty, it is the only diagnostic
+func runGoCommand(ctx context.Context, dir string, env []string, message string, name string, args ...string) (string, error) {
+	cmd := compilerCommand(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	stdout, stderr, err := runCompilerCommand(cmd)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return stdout.String() + stderr.String(), err
+	}
+	compileErr := &CompileError{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitErr.ExitCode(),
+	}
+	if message != "" {
+		compileErr.Diagnostics = []Diagnostic{{Severity: "error", Message: message}}
+		return "", compileErr
+	}
+	for _, m := range goBuildError.FindAllStringSubmatch(compileErr.Stdout+compileErr.Stderr, -1) {
+		line, _ := strconv.Atoi(m[2])
+		column, _ := strconv.Atoi(m[3])
+		compileErr.Diagnostics = append(compileErr.Diagnostics, Diagnostic{
+			File:     strings.TrimPrefix(m[1], "./"),
+			Line:     line,
+			Column:   column,
+			Severity: "error",
+			Message:  m[4],
+		})
+	}
+	return "", compileErr
+}
+
+// goCompileError is the error of the diagnostics found by the proxy, printed as the go tools do
+func goCompileError(diagnostics []Diagnostic) error {
+	var out bytes.Buffer
+	for _, d := range diagnostics {
+		d.Severity = ""
+		fmt.Fprintln(&out, d.String())
+	}
+	return &CompileError{Stderr: out.String(), Diagnostics: diagnostics}
+}
+
+// goDiagnostic is a diagnostic at a position of the sources, with the file relative to the sources
+func goDiagnostic(fset *token.FileSet, srcDir string, pos token.Pos, message string) Diagnostic {
+	p := fset.Position(pos)
+	return Diagnostic{File: relPath(srcDir, p.Filename), Line: p.Line, Column: p.Column, Severity: "error", Message: message}
+}
+
+// parseGoSources parses all the sources, so syntax errors are reported for all of them with their location,
+// returning the files of the package of the action
+func parseGoSources(fset *token.FileSet, srcDir string, pkgDir string) ([]*ast.File, []Diagnostic) {
+	var files []*ast.File
+	var diagnostics []Diagnostic
+	filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != srcDir && (d.Name() == "vendor" || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				diagnostics = append(diagnostics, Diagnostic{
+					File:     relPath(srcDir, e.Pos.Filename),
+					Line:     e.Pos.Line,
+					Column:   e.Pos.Column,
+					Severity: "error",
+					Message:  e.Msg,
+				})
+			}
+		} else if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{File: relPath(srcDir, path), Severity: "error", Message: err.Error()})
+		}
+		if file != nil && filepath.Dir(path) == pkgDir {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, diagnostics
+}
+
+// relPath is the path relative to the sources, if possible
+func relPath(srcDir string, path string) string {
+	if rel, err := filepath.Rel(srcDir, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// hasGoMain tells if the action has its own main function in the main package
+func hasGoMain(files []*ast.File) bool {
+	for _, file := range files {
+		if file.Name.Name != "main" {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" &&
+				fn.Type.Params.NumFields() == 0 && fn.Type.Results.NumFields() == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goEntryNames are the names the function of the action can have, for the main of the init:
+// the name with the first letter uppercase, or else capitalized as the compile scripts do, so hello is Hello
+func goEntryNames(main string) []string {
+	if main == "" {
+		return []string{"Main"}
+	}
+	name := strings.ToUpper(main[:1]) + main[1:]
+	capitalized := strings.ToUpper(main[:1]) + strings.ToLower(main[1:])
+	if name == capitalized {
+		return []string{name}
+	}
+	return []string{name, capitalized}
+}
+
+// findGoEntry finds the function of the action in the main package
+func findGoEntry(files []*ast.File, main string) *ast.FuncDecl {
+	for _, name := range goEntryNames(main) {
+		for _, file := range files {
+			if file.Name.Name != "main" {
+				continue
+			}
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == name {
+					return fn
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// debugGo prepares the action to run under the debugger, moving the sources in the binaries
+func debugGo(srcDir string, binDir string, port string, execEnv string) error {
+	if execEnv != "" {
+		if err := os.WriteFile(filepath.Join(srcDir, "exec.env"), []byte(execEnv), 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(binDir); err != nil {
+		return err
+	}
+	if err := os.Rename(srcDir, binDir); err != nil {
+		return err
+	}
+	script := fmt.Sprintf(`#!/bin/bash
+cd "$(dirname $0)"
+export GOCACHE=/tmp
+export PATH=%s
+exec script -q  -c '/go/bin/dlv debug --headless --listen=127.0.0.1:%s --continue --accept-multiclient --log-dest /tmp/delve.log'
+`, os.Getenv("PATH"), port)
+	return os.WriteFile(filepath.Join(binDir, "exec"), []byte(script), 0755)
+}
@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const BUILTIN_GO = "builtin:go"
+
+// printCompileError prints the diagnostics of a failed compilation
+func printCompileError(err error) {
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		fmt.Println(err)
+		return
+	}
+	for _, d := range compileErr.Diagnostics {
+		fmt.Println(d)
+	}
+}
+
+func Example_builtinGo() {
+	N := "12"
+	sys(PREP, "hello.src", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, BUILTIN_GO, ProxyModeNone), os.Stdout, os.Stderr)
+	fmt.Println(ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin"))
+	sys(CHECK, TMP+N+"/bin/exec")
+	// Output:
+	// <nil>
+	// _test/compile/12/bin/exec: application/x-executable
+	// name=Mike
+	// {"message":"Hello, Mike!"}
+}
+
+func Example_builtinGo_typed() {
+	N := "13"
+	sys(PREP, "typed.src", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, BUILTIN_GO, ProxyModeNone), os.Stdout, os.Stderr)
+	fmt.Println(ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin"))
+	sys(CHECK, TMP+N+"/bin/exec")
+	// Output:
+	// <nil>
+	// _test/compile/13/bin/exec: application/x-executable
+	// name=Mike
+	// {"greeting":"Hello, Mike!"}
+}
+
+func Example_builtinGo_hello() {
+	N := "14"
+	sys(PREP, "hello1.src", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, BUILTIN_GO, ProxyModeNone), os.Stdout, os.Stderr)
+	fmt.Println(ap.CompileAction("hello", TMP+N+"/src", TMP+N+"/bin"))
+	sys(CHECK, TMP+N+"/bin/exec")
+	// Output:
+	// <nil>
+	// _test/compile/14/bin/exec: application/x-executable
+	// name=Mike
+	// {"hello":"Hello, Mike!"}
+}
+
+func Example_builtinGo_withMain() {
+	N := "15"
+	sys(PREP, "hi.src", N, "exec")
+	ap := NewActionProxy(testConfig(TMP, BUILTIN_GO, ProxyModeNone), os.Stdout, os.Stderr)
+	fmt.Println(ap.CompileAction("main", TMP+N+"/src", TMP+N+"/bin"))
+	sys(TMP + N + "/bin/exec")
+	// Output:
+	// <nil>
+	// hi
+}
+
+func Example_builtinGo_errors() {
+	ap := NewActionProxy(testConfig(TMP, BUILTIN_GO, ProxyModeNone), os.Stdout, os.Stderr)
+	// syntax errors are found parsing, before building
+	sys(PREP, "error.src", "16", "exec")
+	printCompileError(ap.CompileAction("main", TMP+"16/src", TMP+"16/bin"))
+	// the types of the signature are checked before building
+	sys(PREP, "badsig.src", "17", "exec")
+	printCompileError(ap.CompileAction("main", TMP+"17/src", TMP+"17/bin"))
+	// the function must be there
+	sys(PREP, "hello.src", "18", "exec")
+	printCompileError(ap.CompileAction("hello", TMP+"18/src", TMP+"18/bin"))
+	// type errors are found building
+	sys(PREP, "typeerror.src", "19", "exec")
+	printCompileError(ap.CompileAction("main", TMP+"19/src", TMP+"19/bin"))
+	// Output:
+	// exec__.go:21:9: error: expected ';', found error
+	// exec__.go:22:3: error: expected '}', found 'EOF'
+	// exec__.go:20:6: error: the action must return a map, a slice, an array or a struct, not a string
+	// error: cannot find the function Hello of the action
+	// exec__.go:21:9: error: undefined: missing
+}
+
+func Example_builtinGo_init() {
+	os.Setenv("OW_WAIT_FOR_ACK", "1")
+	defer os.Unsetenv("OW_WAIT_FOR_ACK")
+	ts, cur, log := startTestServer(BUILTIN_GO)
+	doInit(ts, initCode("_test/typed.src", ""))
+	doRun(ts, "")
+	doRun(ts, `{"name":""}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"greeting":"Hello, Mike!"}
+	// 200 {"error":"missing name"}
+	// name=Mike
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_builtinGo_unknown() {
+	ap := NewActionProxy(testConfig(TMP, "builtin:cobol", ProxyModeNone), os.Stdout, os.Stderr)
+	fmt.Println(ap.CompileAction("main", TMP+"0/src", TMP+"0/bin"))
+	// Output:
+	// unknown builtin compiler cobol
+}
+
+func TestGoEntryNames(t *testing.T) {
+	assert.Equal(t, []string{"Main"}, goEntryNames("main"))
+	assert.Equal(t, []string{"Hello_world"}, goEntryNames("hello_world"))
+	assert.Equal(t, []string{"HelloWorld", "Helloworld"}, goEntryNames("helloWorld"))
+	assert.Equal(t, []string{"Main"}, goEntryNames(""))
+}
+
+func TestGoEnv(t *testing.T) {
+	t.Setenv("GOPATH", "/home/go")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOPROXY", "off")
+	env := goEnv(map[string]string{"__OW_ACTION_NAME": "hello"})
+	assert.Contains(t, env, "GO111MODULE=on")
+	assert.Contains(t, env, "GOPATH=/home/go")
+	assert.Contains(t, env, "__OW_ACTION_NAME=hello")
+	assert.NotContains(t, env, "GOFLAGS=-mod=mod")
+	assert.NotContains(t, env, "GOPROXY=off")
+}
@@ -0,0 +1,378 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// initDecoder reads an init request without keeping the code in memory:
+// the code, that can be hundreds of megabytes, is unescaped straight to a temporary file,
+// while all the other fields are small and decoded by encoding/json
+type initDecoder struct {
+	r *bufio.Reader
+}
+
+// decodeInitRequest decodes an init request, streaming the code to a temporary file.
+// The file is in the codeFile field of the value, positioned at the beginning;
+// the caller must close and remove it.
+func decodeInitRequest(body io.Reader) (initRequest, error) {
+	d := &initDecoder{bufio.NewReaderSize(body, 64*1024)}
+	var request initRequest
+	var codeFile *os.File
+	var valueFields map[string]json.RawMessage
+	fields, err := d.object(func(key string) (bool, error) {
+		if key != "value" || d.peek() != '{' {
+			return false, nil
+		}
+		var err error
+		valueFields, err = d.object(func(key string) (bool, error) {
+			if key != "code" || d.peek() != '"' {
+				return false, nil
+			}
+			if codeFile != nil {
+				codeFile.Close()
+				os.Remove(codeFile.Name())
+			}
+			codeFile, err = d.stringToFile()
+			return true, err
+		})
+		return true, err
+	})
+	if err == nil {
+		// nothing can follow the request but blanks
+		if c := d.peek(); c != 0 || d.r.Buffered() > 0 {
+			err = fmt.Errorf("invalid character %q after top-level value", c)
+		}
+	}
+	if err == nil {
+		err = unmarshalFields(fields, &request)
+	}
+	if err == nil && valueFields != nil {
+		err = unmarshalFields(valueFields, &request.Value)
+	}
+	if err == nil && codeFile != nil {
+		var size int64
+		if size, err = codeFile.Seek(0, io.SeekCurrent); err == nil && size == 0 {
+			// an empty code is like no code at all
+			codeFile.Close()
+			os.Remove(codeFile.Name())
+			codeFile = nil
+		} else if err == nil {
+			_, err = codeFile.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		if codeFile != nil {
+			codeFile.Close()
+			os.Remove(codeFile.Name())
+		}
+		return initRequest{}, err
+	}
+	request.Value.codeFile = codeFile
+	return request, nil
+}
+
+// unmarshalFields decodes the fields collected from an object
+func unmarshalFields(fields map[string]json.RawMessage, v interface{}) error {
+	if fields == nil {
+		return nil
+	}
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// peek returns the next non blank character, without consuming it
+func (d *initDecoder) peek() byte {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			d.r.UnreadByte()
+			return c
+		}
+	}
+}
+
+// expect consumes the next non blank character, checking it is the expected one
+func (d *initDecoder) expect(what byte, context string) error {
+	c := d.peek()
+	if c == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	d.r.ReadByte()
+	if c != what {
+		return fmt.Errorf("invalid character %q %s", c, context)
+	}
+	return nil
+}
+
+// object reads an object, calling handle for each key: when the handler does not consume the value,
+// the raw value is collected in the returned fields. If the value is not an object,
+// encoding/json reports the error, so it is the same as decoding the whole request.
+func (d *initDecoder) object(handle func(key string) (bool, error)) (map[string]json.RawMessage, error) {
+	if d.peek() != '{' {
+		raw, err := d.raw()
+		if err != nil {
+			return nil, err
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("expected an object")
+	}
+	d.r.ReadByte()
+	fields := map[string]json.RawMessage{}
+	if d.peek() == '}' {
+		d.r.ReadByte()
+		return fields, nil
+	}
+	for {
+		if d.peek() != '"' {
+			return nil, d.expect('"', "looking for beginning of object key string")
+		}
+		rawKey, err := d.raw()
+		if err != nil {
+			return nil, err
+		}
+		var key string
+		if err := json.Unmarshal(rawKey, &key); err != nil {
+			return nil, err
+		}
+		if err := d.expect(':', "after object key"); err != nil {
+			return nil, err
+		}
+		handled, err := handle(key)
+		if err != nil {
+			return nil, err
+		}
+		if !handled {
+			if fields[key], err = d.raw(); err != nil {
+				return nil, err
+			}
+		}
+		switch d.peek() {
+		case ',':
+			d.r.ReadByte()
+		case '}':
+			d.r.ReadByte()
+			return fields, nil
+		default:
+			return nil, d.expect('}', "after object key:value pair")
+		}
+	}
+}
+
+// raw reads a value as it is, leaving to encoding/json the decoding and the validation
+func (d *initDecoder) raw() (json.RawMessage, error) {
+	var buf bytes.Buffer
+	depth := 0
+	inString := false
+	escaped := false
+	first := d.peek()
+	for {
+		c, err := d.r.ReadByte()
+		if err == io.EOF && depth == 0 && !inString && first != '"' && buf.Len() > 0 {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if inString {
+			buf.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				if depth == 0 {
+					return buf.Bytes(), nil
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']', ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				// end of a literal, the delimiter belongs to the container
+				d.r.UnreadByte()
+				return buf.Bytes(), nil
+			}
+			if c == '}' || c == ']' {
+				depth--
+				if depth == 0 {
+					buf.WriteByte(c)
+					return buf.Bytes(), nil
+				}
+			}
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// stringToFile unescapes a string to a temporary file
+func (d *initDecoder) stringToFile() (*os.File, error) {
+	file, err := os.CreateTemp("", "code-")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(file, 64*1024)
+	err = d.stringTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// stringTo unescapes a string to the writer, copying the plain runs of characters as they are.
+// As encoding/json does, invalid UTF-8 and unpaired surrogates become U+FFFD, while raw control characters are errors.
+func (d *initDecoder) stringTo(w *bufio.Writer) error {
+	if err := d.expect('"', "looking for beginning of value"); err != nil {
+		return err
+	}
+	for {
+		if _, err := d.r.Peek(1); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		buf, _ := d.r.Peek(d.r.Buffered())
+		if len(buf) < utf8.UTFMax {
+			// a rune split at the end of the buffer is read whole
+			buf, _ = d.r.Peek(utf8.UTFMax)
+		}
+		start, n := 0, 0
+		for n < len(buf) {
+			c := buf[n]
+			if c == '"' || c == '\\' || c < ' ' {
+				break
+			}
+			if c < utf8.RuneSelf {
+				n++
+				continue
+			}
+			if !utf8.FullRune(buf[n:]) && n > 0 {
+				// the rest of the rune is read with the next buffer
+				break
+			}
+			r, size := utf8.DecodeRune(buf[n:])
+			if r == utf8.RuneError && size == 1 {
+				w.Write(buf[start:n])
+				w.WriteRune(utf8.RuneError)
+				start = n + 1
+			}
+			n += size
+		}
+		w.Write(buf[start:n])
+		if n == len(buf) || (buf[n] != '"' && buf[n] != '\\' && buf[n] >= ' ') {
+			d.r.Discard(n)
+			continue
+		}
+		c := buf[n]
+		d.r.Discard(n + 1)
+		switch {
+		case c == '"':
+			return nil
+		case c < ' ':
+			return fmt.Errorf("invalid character %q in string literal", c)
+		}
+		if err := d.escapeTo(w); err != nil {
+			return err
+		}
+	}
+}
+
+// escapeTo unescapes the escape sequence after a backslash to the writer
+func (d *initDecoder) escapeTo(w *bufio.Writer) error {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	switch c {
+	case '"', '\\', '/':
+		w.WriteByte(c)
+	case 'b':
+		w.WriteByte('\b')
+	case 'f':
+		w.WriteByte('\f')
+	case 'n':
+		w.WriteByte('\n')
+	case 'r':
+		w.WriteByte('\r')
+	case 't':
+		w.WriteByte('\t')
+	case 'u':
+		r, err := d.hex4()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) {
+			// the second half of a surrogate pair is consumed only if it is valid,
+			// otherwise the first half is replaced and the next escape is read on its own
+			r2 := rune(-1)
+			if next, _ := d.r.Peek(6); len(next) == 6 && next[0] == '\\' && next[1] == 'u' {
+				if n, err := strconv.ParseUint(string(next[2:]), 16, 16); err == nil {
+					r2 = rune(n)
+				}
+			}
+			if r = utf16.DecodeRune(r, r2); r != utf8.RuneError {
+				d.r.Discard(6)
+			}
+		}
+		w.WriteRune(r)
+	default:
+		return fmt.Errorf("invalid character %q in string escape code", c)
+	}
+	return nil
+}
+
+// hex4 reads the four hex digits of an \u escape
+func (d *initDecoder) hex4() (rune, error) {
+	var digits [4]byte
+	if _, err := io.ReadFull(d.r, digits[:]); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := strconv.ParseUint(string(digits[:]), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid \\u escape %q", digits)
+	}
+	return rune(n), nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decodeWithCode decodes an init request reading back the streamed code
+func decodeWithCode(t *testing.T, body string) initRequest {
+	request, err := decodeInitRequest(strings.NewReader(body))
+	require.NoError(t, err)
+	if code := request.Value.codeFile; code != nil {
+		buf, err := io.ReadAll(code)
+		require.NoError(t, err)
+		code.Close()
+		os.Remove(code.Name())
+		request.Value.Code = string(buf)
+		request.Value.codeFile = nil
+	}
+	return request
+}
+
+func TestDecodeInitRequest(t *testing.T) {
+	bodies := []string{
+		`{}`,
+		`{"value": null}`,
+		`{"value": {"code": null, "main": "main"}}`,
+		`{"value": {"code": ""}}`,
+		` { "proxiedActionID" : "id", "value" : { "main" : "hello", "binary" : true , "code" : "UEsDBA==" , "env" : {"A": "1", "B": [1, {"c": "}"}]} } } `,
+		`{"value": {"code": "#!/bin/sh\necho \"hello\" \\\/ è 😀 \t end"}, "extra": [true, false, null, 1.5e3]}`,
+		`{"value": {"env": {"x": "\"code\": \"no\""}, "code": "first"}}`,
+		`{"value": {"url": "https://example.com/a.zip", "sha256": "abc"}}`,
+		// invalid UTF-8 and unpaired surrogates are replaced as encoding/json does
+		"{\"value\": {\"code\": \"a\xffb\xe2\x82c\xed\xa0\x80\"}}",
+		`{"value": {"code": "\ud83d\ude00 \ud800\n \ud800\u0041 \udc00x \ud800\ud800\udc00 \ud800"}}`,
+		// runes split at the end of the buffer
+		`{"value": {"code": "x` + strings.Repeat("è😀", 30000) + `"}}`,
+		"{\"value\": {\"code\": \"x" + strings.Repeat("\xe2\x82", 30000) + "\"}} \n",
+	}
+	for _, body := range bodies {
+		var expected initRequest
+		require.NoError(t, json.Unmarshal([]byte(body), &expected), body)
+		require.Equal(t, expected, decodeWithCode(t, body), body)
+	}
+}
+
+func TestDecodeInitRequest_errors(t *testing.T) {
+	bodies := map[string]string{
+		"XXX":                               "invalid character 'X' looking for beginning of value",
+		`{"value": {"code": "abc`:           "unexpected EOF",
+		`{"value": {"code": "a\qb"}}`:       "invalid character 'q' in string escape code",
+		`{"value": {"main": 1}}`:            "cannot unmarshal number",
+		`{"value" {}}`:                      "invalid character '{' after object key",
+		`{"value": {} "x": 1}`:              "after object key:value pair",
+		"{\"value\": {\"code\": \"a\nb\"}}": `invalid character '\n' in string literal`,
+		`{"value": {}} x`:                   "invalid character 'x' after top-level value",
+		`{"value": {}}{}`:                   "invalid character '{' after top-level value",
+	}
+	for body, msg := range bodies {
+		_, err := decodeInitRequest(strings.NewReader(body))
+		require.ErrorContains(t, err, msg, body)
+	}
+}
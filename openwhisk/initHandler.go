@@ -18,14 +18,18 @@
 package openwhisk
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha512"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type initBodyRequest struct {
@@ -33,6 +37,16 @@ type initBodyRequest struct {
 	Binary bool                   `json:"binary,omitempty"`
 	Main   string                 `json:"main,omitempty"`
 	Env    map[string]interface{} `json:"env,omitempty"`
+	// URL references the code, instead of sending it inline: an http(s) URL, or a file URL or an absolute path in the code directory
+	URL string `json:"url,omitempty"`
+	// SHA256 is the digest the code referenced by URL must have
+	SHA256 string `json:"sha256,omitempty"`
+	// Signature is the base64 ed25519 signature of the decoded code, required if OW_SIGNING_KEYS is set
+	Signature string `json:"signature,omitempty"`
+	// KeyID identifies the key of the signature among OW_SIGNING_KEYS
+	KeyID string `json:"key_id,omitempty"`
+	// codeFile holds the code when it is streamed to disk by decodeInitRequest, instead of Code
+	codeFile *os.File
 }
 
 type initRequest struct {
@@ -52,11 +66,19 @@ func sendOK(w http.ResponseWriter) {
 }
 
 func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := ap.tracer.startSpan(r.Context(), "init", spanKindServer, r.Header)
+	defer span.end()
+	r = r.WithContext(ctx)
+
+	// the reloads of WatchAction are initializations too
+	ap.initMu.Lock()
+	defer ap.initMu.Unlock()
+
 	// you can do multiple initializations when debugging
 	if ap.initialized && !Debugging {
 		msg := "Cannot initialize the action more than once."
 		sendError(w, http.StatusForbidden, msg)
-		log.Println(msg)
+		ap.logger.Warn(msg)
 		return
 	}
 
@@ -69,31 +91,33 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 		Debug("compiler: " + ap.compiler)
 	}
 
-	// read body of the request
-	body, err := io.ReadAll(r.Body)
+	// decode request parameters, streaming the code to disk, within the size limit of the action
 	defer r.Body.Close()
-	if err != nil {
-		sendError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
-		return
+	if limits := ap.config.ArchiveLimits; limits.Size > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxInitBody(limits.Size))
 	}
-
-	// decode request parameters
-	if len(body) < 1000 {
-		Debug("init: decoding %s\n", string(body))
-	}
-
-	var request initRequest
-	err = json.Unmarshal(body, &request)
+	request, err := decodeInitRequest(r.Body)
 	if err != nil {
+		// the decoder can hide the error of the body, that a MaxBytesReader returns again
+		var tooLarge *http.MaxBytesError
+		if _, bodyErr := r.Body.Read(nil); errors.As(bodyErr, &tooLarge) {
+			sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the init request exceeds the size limit of %d bytes", tooLarge.Limit))
+			return
+		}
 		sendError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshaling request: %v", err))
 		return
 	}
+	if code := request.Value.codeFile; code != nil {
+		defer os.Remove(code.Name())
+		defer code.Close()
+	}
 
 	if ap.proxyMode == ProxyModeServer {
 		if ap.serverProxyData == nil {
 			ap.serverProxyData = &ServerProxyData{actions: make(map[RemoteAPKey]*RemoteAPValue)}
 		}
-		if ok := doRemoteInit(ap, request, w); !ok {
+		if ok := doRemoteInit(r.Context(), ap, request, w); !ok {
+			span.setError(fmt.Errorf("remote initialization failed"))
 			return
 		}
 
@@ -101,8 +125,9 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ap.doInit(request, w); err != nil {
-		Debug("Error initializing action: %v", err)
+	if err := ap.doInit(r.Context(), request, w); err != nil {
+		span.setError(err)
+		ap.logger.Warn("cannot initialize the action", "error", err)
 		return
 	}
 
@@ -111,7 +136,7 @@ func (ap *ActionProxy) initHandler(w http.ResponseWriter, r *http.Request) {
 
 // doRemoteInit initializes a remote action.
 // Returns true if the initialization was successful, false otherwise.
-func doRemoteInit(ap *ActionProxy, request initRequest, w http.ResponseWriter) bool {
+func doRemoteInit(ctx context.Context, ap *ActionProxy, request initRequest, w http.ResponseWriter) bool {
 	Debug("Remote initialization started.")
 
 	// Get the action code hash from the client request
@@ -146,8 +171,13 @@ func doRemoteInit(ap *ActionProxy, request initRequest, w http.ResponseWriter) b
 	}
 
 	Debug("Creating nested action proxy...")
-	innerActionProxy := NewActionProxy(ap.baseDir, ap.compiler, outLog, errLog, ProxyModeNone)
-	if err := innerActionProxy.doInit(request, w); err != nil {
+	innerConfig := *ap.config
+	innerConfig.ActionDir, innerConfig.ProxyMode = ap.baseDir, ProxyModeNone
+	innerActionProxy := NewActionProxy(&innerConfig, outLog, errLog)
+	innerActionProxy.SetCompiler(ap.customCompiler)
+	innerActionProxy.tracer = ap.tracer
+	innerActionProxy.logger = ap.logger.With("code_hash", actionCodeHash)
+	if err := innerActionProxy.doInit(ctx, request, w); err != nil {
 		return false
 	}
 
@@ -164,9 +194,11 @@ func doRemoteInit(ap *ActionProxy, request initRequest, w http.ResponseWriter) b
 	return true
 }
 
-func (ap *ActionProxy) doInit(request initRequest, w http.ResponseWriter) error {
+// doInit initializes the action; the compilation is cancelled when the context is done,
+// as when the client disconnects
+func (ap *ActionProxy) doInit(ctx context.Context, request initRequest, w http.ResponseWriter) error {
 	// request with empty code - stop any executor but return ok
-	if request.Value.Code == "" {
+	if request.Value.Code == "" && request.Value.codeFile == nil && request.Value.URL == "" {
 		sendError(w, http.StatusForbidden, "Missing main/no code to execute.")
 		return fmt.Errorf("code in body is empty")
 	}
@@ -180,39 +212,105 @@ func (ap *ActionProxy) doInit(request initRequest, w http.ResponseWriter) error
 		main = "main"
 	}
 
-	// extract code eventually decoding it
-	var buf []byte
-	if request.Value.Binary {
-		Debug("it is binary code")
-		b, err := base64.StdEncoding.DecodeString(request.Value.Code)
+	// extract code eventually decoding it, or fetch it by reference
+	var src io.Reader
+	inline := true
+	switch {
+	case request.Value.codeFile != nil:
+		src = request.Value.codeFile
+	case request.Value.Code != "":
+		src = strings.NewReader(request.Value.Code)
+	default:
+		inline = false
+		Debug("it is a reference to the code")
+		file, status, err := fetchCode(request.Value.URL, request.Value.SHA256, ap.config.CodeDir, ap.config.ArchiveLimits.Size)
 		if err != nil {
-			sendError(w, http.StatusBadRequest, "cannot decode the request: "+err.Error())
+			sendError(w, status, err.Error())
 			return err
 		}
-		buf = b
-	} else {
+		defer os.Remove(file.Name())
+		defer file.Close()
+		src = file
+	}
+	if inline && request.Value.Binary {
+		Debug("it is binary code")
+		src = &decodeReader{base64.NewDecoder(base64.StdEncoding, src)}
+	} else if inline {
 		Debug("it is source code")
-		buf = []byte(request.Value.Code)
+	}
+
+	// signed actions are verified before extracting anything
+	keys, err := parseSigningKeys(ap.config.SigningKeys)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return err
+	}
+	if len(keys) > 0 {
+		var verified *os.File
+		if verified, err = ap.verifyCode(keys, src, request.Value); err != nil {
+			var signatureErr *SignatureError
+			if errors.As(err, &signatureErr) {
+				sendError(w, http.StatusForbidden, err.Error())
+				return err
+			}
+		} else {
+			defer os.Remove(verified.Name())
+			defer verified.Close()
+			src = verified
+		}
 	}
 
 	// if a compiler is defined try to compile
-	_, err := ap.ExtractAndCompile(&buf, main)
+	if err == nil {
+		_, err = ap.ExtractAndCompileFromContext(ctx, src, main)
+	}
+	if errors.Is(err, ErrCompileTimeout) {
+		sendError(w, http.StatusGatewayTimeout, err.Error())
+		return err
+	}
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		sendError(w, http.StatusBadRequest, "cannot decode the request: "+decodeErr.Error())
+		return err
+	}
+	var limitErr *ArchiveLimitError
+	if errors.As(err, &limitErr) {
+		sendError(w, http.StatusRequestEntityTooLarge, limitErr.Error())
+		return err
+	}
+	var archErr *ArchitectureError
+	if errors.As(err, &archErr) {
+		sendError(w, http.StatusBadRequest, archErr.Error())
+		return err
+	}
 	if err != nil {
-		if os.Getenv("OW_LOG_INIT_ERROR") == "" {
-			sendError(w, http.StatusBadGateway, err.Error())
+		// the diagnostics of the compiler point to the lines of the code, so they are always returned
+		var diagnostics []Diagnostic
+		var compileErr *CompileError
+		if errors.As(err, &compileErr) {
+			diagnostics = compileErr.Diagnostics
+		}
+		if !ap.config.LogInitError {
+			sendErrorResponse(w, http.StatusBadGateway, ErrResponse{Error: err.Error(), Diagnostics: diagnostics})
 		} else {
 			ap.errFile.Write([]byte(err.Error() + "\n"))
 			ap.outFile.Write([]byte(OutputGuard))
 			ap.errFile.Write([]byte(OutputGuard))
-			sendError(w, http.StatusBadGateway, "The action failed to generate or locate a binary. See logs for details.")
+			sendErrorResponse(w, http.StatusBadGateway, ErrResponse{
+				Error:       "The action failed to generate or locate a binary. See logs for details.",
+				Diagnostics: diagnostics,
+			})
 		}
 		return err
 	}
 
 	// start an action
+	_, start := ap.tracer.startChildSpan(ctx, "start")
 	err = ap.StartLatestAction()
+	start.setError(err)
+	start.end()
 	if err != nil {
-		if os.Getenv("OW_LOG_INIT_ERROR") == "" {
+		if !ap.config.LogInitError {
 			sendError(w, http.StatusBadGateway, "cannot start action: "+err.Error())
 		} else {
 			ap.errFile.Write([]byte(err.Error() + "\n"))
@@ -227,11 +325,89 @@ func (ap *ActionProxy) doInit(request initRequest, w http.ResponseWriter) error
 	return nil
 }
 
+// verifyCode spools the decoded code to a temporary file, hashing it while it is written,
+// and checks the Ed25519ph signature of its SHA-512 from the init fields or else from the init env.
+// It returns the file positioned at the beginning, that the caller must close and remove.
+func (ap *ActionProxy) verifyCode(keys map[string]ed25519.PublicKey, src io.Reader, value initBodyRequest) (*os.File, error) {
+	limits := ap.config.ArchiveLimits
+	file, err := os.CreateTemp("", "code-")
+	if err != nil {
+		return nil, err
+	}
+	hash := sha512.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), &sizeReader{r: src, budget: &extractBudget{limits: limits}})
+	if err == nil {
+		signature, keyID := value.Signature, value.KeyID
+		if signature == "" {
+			signature, _ = value.Env[SignatureEnv].(string)
+			keyID, _ = value.Env[SignatureKeyIDEnv].(string)
+		}
+		err = verifySignature(keys, hash.Sum(nil), signature, keyID)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	Debug("verified the signature of the action")
+	return file, nil
+}
+
+// initOverhead is the room left in the body of an init request for the fields but the code
+const initOverhead = 1 << 20
+
+// maxInitBody is the maximum size of the body of an init request, for an action of the given maximum size:
+// the code can be encoded in base64
+func maxInitBody(size int64) int64 {
+	return size/3*4 + 4 + initOverhead
+}
+
+// decodeError is an error decoding the base64 code, while it is streamed
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+// decodeReader tells apart the errors decoding the code from the errors extracting it
+type decodeReader struct {
+	r io.Reader
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &decodeError{err}
+	}
+	return n, err
+}
+
 // ExtractAndCompile decode the buffer and if a compiler is defined, compile it also
 func (ap *ActionProxy) ExtractAndCompile(buf *[]byte, main string) (string, error) {
+	if buf == nil {
+		return "", fmt.Errorf("no file")
+	}
+	return ap.ExtractAndCompileFrom(bytes.NewReader(*buf), main)
+}
+
+// ExtractAndCompileFrom is like ExtractAndCompile but streams the action from a reader
+func (ap *ActionProxy) ExtractAndCompileFrom(src io.Reader, main string) (string, error) {
+	return ap.ExtractAndCompileFromContext(context.Background(), src, main)
+}
+
+// ExtractAndCompileFromContext is like ExtractAndCompileFrom, cancelling the compilation when the context is done
+func (ap *ActionProxy) ExtractAndCompileFromContext(ctx context.Context, src io.Reader, main string) (string, error) {
 
 	// extract action in src folder
-	file, err := ap.ExtractAction(buf, "src")
+	_, extract := ap.tracer.startChildSpan(ctx, "extract")
+	file, err := ap.ExtractActionFrom(src, "src")
+	extract.setError(err)
+	extract.end()
 	if err != nil {
 		return "", err
 	}
@@ -247,7 +423,7 @@ func (ap *ActionProxy) ExtractAndCompile(buf *[]byte, main string) (string, erro
 	binFile := filepath.Join(binDir, "exec")
 
 	// if the file is already compiled or there is no compiler just move it from src to bin
-	if ap.compiler == "" || isCompiled(file) {
+	if !ap.hasCompiler() || isCompiled(file) {
 		os.Rename(srcDir, binDir)
 		return binFile, nil
 	}
@@ -255,7 +431,11 @@ func (ap *ActionProxy) ExtractAndCompile(buf *[]byte, main string) (string, erro
 	// ok let's try to compile
 	Debug("compiling: %s main: %s", file, main)
 	os.Mkdir(binDir, 0755)
-	err = ap.CompileAction(main, srcDir, binDir)
+	_, compile := ap.tracer.startChildSpan(ctx, "compile")
+	compile.setAttribute("action.main", main)
+	err = ap.CompileActionContext(ctx, main, srcDir, binDir)
+	compile.setError(err)
+	compile.end()
 	if err != nil {
 		return "", err
 	}
@@ -20,6 +20,7 @@ package openwhisk
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 )
 
@@ -208,6 +209,64 @@ func Example_compile_withZipSrc() {
 	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
 }
 
+func Example_compile_typed() {
+	comp, _ := filepath.Abs("common/gobuild.py")
+	ts, cur, log := startTestServer(comp)
+	doInit(ts, initCode("_test/typed.src", ""))
+	doRun(ts, "")
+	doRun(ts, "{}")
+	doRun(ts, `{"name":1}`)
+	res, status, _ := doPost(ts.URL+"/run", `{"value":{"name":"Mike"},"deadline":"1"}`)
+	fmt.Print(status, " ", res)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"greeting":"Hello, Mike!"}
+	// 200 {"error":"missing name"}
+	// 200 {"error":"cannot decode the input: json: cannot unmarshal number into Go struct field Request.name of type string"}
+	// 200 {"error":"context deadline exceeded"}
+	// name=Mike
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_compile_badSignature() {
+	comp, _ := filepath.Abs("common/gobuild.py")
+	ts, cur, log := startTestServer(comp)
+	doInit(ts, initCode("_test/badsig.src", ""))
+	doRun(ts, "")
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 502 {"error":"exec__.go:20:6: the action must return a map, a slice, an array or a struct, not a string\n","diagnostics":[{"file":"exec__.go","line":20,"column":6,"severity":"error","message":"the action must return a map, a slice, an array or a struct, not a string"}]}
+	// 500 {"error":"no action defined yet"}
+}
+
+func Example_compile_diagnostics() {
+	comp, _ := filepath.Abs("common/gobuild.py")
+	ts, cur, log := startTestServer(comp)
+	doInit(ts, initCode("_test/error.src", ""))
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 502 {"error":"./exec__.go:21:9: syntax error: unexpected name error at end of statement\n","diagnostics":[{"file":"exec__.go","line":21,"column":9,"severity":"error","message":"syntax error: unexpected name error at end of statement"}]}
+}
+
+func Example_compile_timeout() {
+	os.Setenv("OW_COMPILE_TIMEOUT", "500ms")
+	defer os.Unsetenv("OW_COMPILE_TIMEOUT")
+	comp, _ := filepath.Abs("_test/slowcompile.sh")
+	ts, cur, log := startTestServer(comp)
+	doInit(ts, initCode("_test/hello.src", ""))
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 504 {"error":"compilation timed out after 500ms"}
+}
+
 func Example_badinit_nocompiler() {
 	ts, cur, log := startTestServer("")
 	doRun(ts, "")
@@ -0,0 +1,264 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ActionLimits are the resource limits applied to an action process.
+// A zero value means no limit.
+type ActionLimits struct {
+	// Memory is the maximum memory in bytes
+	Memory int64
+	// CPUs is the number of CPUs the action can use (cgroups only)
+	CPUs float64
+	// CPUTime is the maximum CPU time the action can consume
+	CPUTime time.Duration
+	// Files is the maximum number of open files
+	Files int64
+	// Procs is the maximum number of processes
+	Procs int64
+	// Timeout is the maximum wall-clock time of a single run
+	Timeout time.Duration
+}
+
+// LimitError is returned when an action breaches one of its limits
+type LimitError struct {
+	msg string
+}
+
+func (e *LimitError) Error() string {
+	return e.msg
+}
+
+// IsZero checks if no limits are set
+func (l ActionLimits) IsZero() bool {
+	return l == ActionLimits{}
+}
+
+// loadLimits reads the limits from the proxy environment (OW_LIMIT_*)
+// and from the same keys in the init env of the action.
+// When both are set the stricter one wins, so an action cannot raise the limits of the proxy.
+func loadLimits(env map[string]string) (ActionLimits, error) {
+	var limits ActionLimits
+	var err error
+	parse := func(name string, parser func(string) (float64, error)) float64 {
+		res := 0.0
+		for _, val := range []string{os.Getenv(name), env[name]} {
+			if val == "" || err != nil {
+				continue
+			}
+			n, perr := parser(val)
+			if perr != nil || n < 0 {
+				err = fmt.Errorf("invalid %s: %q", name, val)
+				continue
+			}
+			if res == 0 || (n > 0 && n < res) {
+				res = n
+			}
+		}
+		return res
+	}
+	limits.Memory = int64(parse("OW_LIMIT_MEMORY", parseSize))
+	limits.CPUs = parse("OW_LIMIT_CPU", func(s string) (float64, error) { return strconv.ParseFloat(s, 64) })
+	limits.CPUTime = time.Duration(parse("OW_LIMIT_CPU_TIME", parseDuration))
+	limits.Files = int64(parse("OW_LIMIT_FILES", func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }))
+	limits.Procs = int64(parse("OW_LIMIT_PROCS", func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }))
+	limits.Timeout = time.Duration(parse("OW_LIMIT_TIMEOUT", parseDuration))
+	return limits, err
+}
+
+// parseSize parses a size in bytes with an optional k, m or g suffix
+func parseSize(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n * mult, err
+}
+
+// parseDuration parses a duration like 1m30s, or a number of seconds
+func parseDuration(s string) (float64, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n * float64(time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	return float64(d), err
+}
+
+// where to find the cgroup v2 hierarchy and the cgroup of the proxy
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	cgroupSelf = "/proc/self/cgroup"
+)
+
+var (
+	cgroupMu     sync.Mutex
+	cgroupParent string
+	cgroupSeq    atomic.Int64
+)
+
+// actionCgroup is the cgroup v2 sub-group created for an action process
+type actionCgroup struct {
+	dir string
+}
+
+// cgroupBase finds the cgroup v2 of the proxy and prepares it to host sub-groups:
+// the proxy moves itself in a leaf "proxy" group and enables the controllers for the children.
+// It fails if the cgroup is not v2 or it is not delegated to the container.
+func cgroupBase() (string, error) {
+	cgroupMu.Lock()
+	defer cgroupMu.Unlock()
+	if cgroupParent != "" {
+		return cgroupParent, nil
+	}
+	controllers, err := os.ReadFile(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("cgroup v2 not available")
+	}
+	self, err := os.ReadFile(cgroupSelf)
+	if err != nil {
+		return "", err
+	}
+	base := ""
+	scanner := bufio.NewScanner(bytes.NewReader(self))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			base = filepath.Join(cgroupRoot, path)
+		}
+	}
+	if base == "" {
+		return "", fmt.Errorf("cannot find the cgroup of the proxy")
+	}
+	if buf, err := os.ReadFile(filepath.Join(base, "cgroup.controllers")); err == nil {
+		controllers = buf
+	}
+	// processes can live only in the leaves, so the proxy moves away
+	leaf := filepath.Join(base, "proxy")
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return "", err
+	}
+	enable := []string{}
+	for _, c := range strings.Fields(string(controllers)) {
+		if c == "memory" || c == "cpu" || c == "pids" {
+			enable = append(enable, "+"+c)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(base, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0644); err != nil {
+		return "", err
+	}
+	cgroupParent = base
+	return base, nil
+}
+
+// newCgroup creates a sub-group with the given limits, where the action process is then started
+func newCgroup(limits ActionLimits) (*actionCgroup, error) {
+	base, err := cgroupBase()
+	if err != nil {
+		return nil, err
+	}
+	cg := &actionCgroup{filepath.Join(base, fmt.Sprintf("action-%d", cgroupSeq.Add(1)))}
+	if err := os.Mkdir(cg.dir, 0755); err != nil {
+		return nil, err
+	}
+	settings := map[string]string{}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if limits.CPUs > 0 {
+		period := 100000
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int(limits.CPUs*float64(period)), period)
+	}
+	if limits.Procs > 0 {
+		settings["pids.max"] = strconv.FormatInt(limits.Procs, 10)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(cg.dir, file), []byte(value), 0644); err != nil && file != "memory.swap.max" {
+			cg.remove()
+			return nil, fmt.Errorf("cannot set %s: %v", file, err)
+		}
+	}
+	return cg, nil
+}
+
+// breach checks the cgroup events to find out if a limit was hit
+func (cg *actionCgroup) breach() error {
+	if cgroupEvent(filepath.Join(cg.dir, "memory.events"), "oom_kill") > 0 {
+		return &LimitError{"the action exceeded the memory limit"}
+	}
+	if cgroupEvent(filepath.Join(cg.dir, "pids.events"), "max") > 0 {
+		return &LimitError{"the action exceeded the process limit"}
+	}
+	return nil
+}
+
+// kill all the processes in the cgroup
+func (cg *actionCgroup) kill() {
+	os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0644)
+}
+
+// remove the cgroup, waiting a bit for the processes to go away
+func (cg *actionCgroup) remove() {
+	for i := 0; i < 20; i++ {
+		if err := os.Remove(cg.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	Debug("cannot remove cgroup %s", cg.dir)
+}
+
+// cgroupEvent reads a counter from a cgroup events file
+func cgroupEvent(file string, key string) int64 {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package openwhisk
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rlimitResources are the rlimits the sandbox launcher can set, by option name
+var rlimitResources = map[string]int{
+	"cpu":    unix.RLIMIT_CPU,
+	"nofile": unix.RLIMIT_NOFILE,
+	"data":   unix.RLIMIT_DATA,
+	"nproc":  unix.RLIMIT_NPROC,
+}
+
+// rlimitOptions returns the rlimits of the action as options of the sandbox launcher,
+// that sets them in the action process before executing it.
+// Memory and processes use rlimits only when the action has no cgroup,
+// and processes only when the action runs as its own user:
+// RLIMIT_NPROC counts all the processes of the user, and it is not enforced for root.
+func rlimitOptions(limits ActionLimits, withCgroup bool, withUser bool) []string {
+	opts := []string{}
+	add := func(name string, soft uint64, hard uint64) {
+		opts = append(opts, fmt.Sprintf("%s=%d:%d", name, soft, hard))
+	}
+	if limits.CPUTime > 0 {
+		// SIGXCPU at the soft limit, SIGKILL one second later
+		secs := uint64((limits.CPUTime + 999999999) / 1000000000)
+		add("cpu", secs, secs+1)
+	}
+	if limits.Files > 0 {
+		add("nofile", uint64(limits.Files), uint64(limits.Files))
+	}
+	if withCgroup {
+		return opts
+	}
+	if limits.Memory > 0 {
+		add("data", uint64(limits.Memory), uint64(limits.Memory))
+	}
+	if limits.Procs > 0 {
+		if withUser {
+			add("nproc", uint64(limits.Procs), uint64(limits.Procs))
+		} else {
+			Debug("the process limit requires a cgroup or OW_ACTION_UID, not applied")
+		}
+	}
+	return opts
+}
+
+// setRlimit applies to the current process a rlimit option name=soft:hard
+func setRlimit(opt string) error {
+	name, value, _ := strings.Cut(opt, "=")
+	resource, ok := rlimitResources[name]
+	soft, hard, _ := strings.Cut(value, ":")
+	cur, err1 := strconv.ParseUint(soft, 10, 64)
+	max, err2 := strconv.ParseUint(hard, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid limit %s", opt)
+	}
+	// syscall.Setrlimit, so the runtime does not restore its own RLIMIT_NOFILE on exec
+	if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: cur, Max: max}); err != nil {
+		return fmt.Errorf("cannot set limit %s: %v", name, err)
+	}
+	return nil
+}
+
+// attachCgroup makes the command start directly in the cgroup open as dir,
+// so the action cannot fork nor allocate before its limits apply
+func attachCgroup(attr *syscall.SysProcAttr, dir *os.File) error {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(dir.Fd())
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// rlimitOptions is supported only on linux, where the sandbox launcher sets the rlimits
+func rlimitOptions(limits ActionLimits, withCgroup bool, withUser bool) []string {
+	Debug("resource limits are not supported on this platform")
+	return nil
+}
+
+// attachCgroup is supported only on linux
+func attachCgroup(attr *syscall.SysProcAttr, dir *os.File) error {
+	return fmt.Errorf("cgroups are not supported on this platform")
+}
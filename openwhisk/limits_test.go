@@ -0,0 +1,179 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLimits(t *testing.T) {
+	limits, err := loadLimits(map[string]string{})
+	require.NoError(t, err)
+	assert.True(t, limits.IsZero())
+
+	limits, err = loadLimits(map[string]string{
+		"OW_LIMIT_MEMORY":   "128m",
+		"OW_LIMIT_CPU":      "0.5",
+		"OW_LIMIT_CPU_TIME": "10",
+		"OW_LIMIT_FILES":    "64",
+		"OW_LIMIT_PROCS":    "16",
+		"OW_LIMIT_TIMEOUT":  "1m30s",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ActionLimits{
+		Memory:  128 << 20,
+		CPUs:    0.5,
+		CPUTime: 10 * time.Second,
+		Files:   64,
+		Procs:   16,
+		Timeout: 90 * time.Second,
+	}, limits)
+
+	_, err = loadLimits(map[string]string{"OW_LIMIT_MEMORY": "lots"})
+	assert.EqualError(t, err, `invalid OW_LIMIT_MEMORY: "lots"`)
+}
+
+func TestLoadLimits_stricterWins(t *testing.T) {
+	os.Setenv("OW_LIMIT_MEMORY", "1g")
+	os.Setenv("OW_LIMIT_TIMEOUT", "10s")
+	defer os.Unsetenv("OW_LIMIT_MEMORY")
+	defer os.Unsetenv("OW_LIMIT_TIMEOUT")
+	limits, err := loadLimits(map[string]string{
+		"OW_LIMIT_MEMORY":  "256m",
+		"OW_LIMIT_TIMEOUT": "1h",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(256<<20), limits.Memory)
+	assert.Equal(t, 10*time.Second, limits.Timeout)
+}
+
+func TestExecutor_timeLimit(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	script := writeScript(t, "#!/bin/sh\nwhile read a; do sleep 10; echo '{}' >&3; done\n")
+	proc := NewExecutor(log, log, script, m)
+	proc.limits.Timeout = 200 * time.Millisecond
+	require.NoError(t, proc.Start(false))
+	_, err := proc.Interact([]byte("{}"))
+	assert.EqualError(t, err, "the action exceeded the time limit of 200ms")
+	proc.Stop()
+}
+
+func TestExecutor_cpuTimeLimit(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	script := writeScript(t, "#!/bin/bash\nwhile read a; do while :; do :; done; done\n")
+	proc := NewExecutor(log, log, script, m)
+	proc.limits.CPUTime = time.Second
+	require.NoError(t, proc.Start(false))
+	_, err := proc.Interact([]byte("{}"))
+	assert.EqualError(t, err, "the action exceeded the cpu time limit of 1s")
+	proc.Stop()
+}
+
+func TestExecutor_rlimits(t *testing.T) {
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	// the limits are already in place when the action starts
+	script := writeScript(t, "#!/bin/bash\nlimits=\"$(ulimit -n) $(ulimit -t)\"\nwhile read a; do echo $limits >&3; done\n")
+	proc := NewExecutor(log, log, script, m)
+	proc.limits.Files = 32
+	proc.limits.CPUTime = 1500 * time.Millisecond
+	require.NoError(t, proc.Start(false))
+	out, err := proc.Interact([]byte("{}"))
+	proc.Stop()
+	require.NoError(t, err)
+	assert.Equal(t, "32 2", strings.TrimSpace(string(out)))
+}
+
+func TestRlimitOptions(t *testing.T) {
+	limits := ActionLimits{CPUTime: time.Second, Files: 64, Memory: 1 << 20, Procs: 8}
+	assert.Equal(t, []string{"cpu=1:2", "nofile=64:64"}, rlimitOptions(limits, true, true))
+	assert.Equal(t, []string{"cpu=1:2", "nofile=64:64", "data=1048576:1048576", "nproc=8:8"}, rlimitOptions(limits, false, true))
+	// without a user of its own, the process limit needs a cgroup
+	assert.Equal(t, []string{"cpu=1:2", "nofile=64:64", "data=1048576:1048576"}, rlimitOptions(limits, false, false))
+}
+
+// fakeCgroup prepares a directory looking like a delegated cgroup v2 hierarchy
+func fakeCgroup(t *testing.T) string {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "ctr"), 0755)
+	os.WriteFile(filepath.Join(root, "ctr", "cgroup.controllers"), []byte("cpu memory pids\n"), 0644)
+	self := filepath.Join(root, "self")
+	os.WriteFile(self, []byte("0::/ctr\n"), 0644)
+	oldRoot, oldSelf := cgroupRoot, cgroupSelf
+	cgroupRoot, cgroupSelf, cgroupParent = root, self, ""
+	t.Cleanup(func() {
+		cgroupRoot, cgroupSelf, cgroupParent = oldRoot, oldSelf, ""
+	})
+	return root
+}
+
+func TestCgroup(t *testing.T) {
+	root := fakeCgroup(t)
+	cg, err := newCgroup(ActionLimits{Memory: 64 << 20, CPUs: 0.25, Procs: 10})
+	require.NoError(t, err)
+	name := filepath.Base(cg.dir)
+	read := func(path ...string) string {
+		buf, _ := os.ReadFile(filepath.Join(append([]string{root, "ctr"}, path...)...))
+		return string(buf)
+	}
+	assert.Equal(t, "+cpu +memory +pids", read("cgroup.subtree_control"))
+	assert.Equal(t, filepath.Join(root, "ctr"), filepath.Dir(cg.dir))
+	assert.Regexp(t, "^action-[0-9]+$", name)
+	assert.Equal(t, "67108864", read(name, "memory.max"))
+	assert.Equal(t, "25000 100000", read(name, "cpu.max"))
+	assert.Equal(t, "10", read(name, "pids.max"))
+	assert.NotEmpty(t, read("proxy", "cgroup.procs"))
+
+	assert.NoError(t, cg.breach())
+	os.WriteFile(filepath.Join(cg.dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644)
+	assert.EqualError(t, cg.breach(), "the action exceeded the memory limit")
+	os.WriteFile(filepath.Join(cg.dir, "memory.events"), []byte("oom_kill 0\n"), 0644)
+	os.WriteFile(filepath.Join(cg.dir, "pids.events"), []byte("max 2\n"), 0644)
+	assert.EqualError(t, cg.breach(), "the action exceeded the process limit")
+}
+
+func TestCgroup_notAvailable(t *testing.T) {
+	root := fakeCgroup(t)
+	os.Remove(filepath.Join(root, "cgroup.controllers"))
+	_, err := newCgroup(ActionLimits{Memory: 64 << 20})
+	assert.EqualError(t, err, "cgroup v2 not available")
+}
+
+func Example_timeLimit() {
+	ts, cur, log := startTestServer("")
+	doInit(ts, `{"value":{"code":"#!/bin/sh\nwhile read a; do sleep 10; done\n","env":{"OW_LIMIT_TIMEOUT":"500ms"}}}`)
+	doRun(ts, "")
+	doRun(ts, "")
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 400 {"error":"the action exceeded the time limit of 500ms"}
+	// 500 {"error":"no action defined yet"}
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
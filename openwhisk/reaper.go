@@ -0,0 +1,122 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// children started by the proxy and waited by os/exec;
+// the reaper must leave them alone, otherwise cmd.Wait fails
+var (
+	childrenMu sync.Mutex
+	children   = map[int]bool{}
+)
+
+// startTracked starts the command and records its pid as one of our children
+func startTracked(cmd *exec.Cmd) error {
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+	err := cmd.Start()
+	if err == nil {
+		children[cmd.Process.Pid] = true
+	}
+	return err
+}
+
+// waitTracked waits for a command started with startTracked
+func waitTracked(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	childrenMu.Lock()
+	delete(children, cmd.Process.Pid)
+	childrenMu.Unlock()
+	return err
+}
+
+// StartZombieReaper reaps orphaned processes when the proxy runs as PID 1 in a container.
+// Processes forked by the actions and reparented to the proxy would be zombies forever otherwise.
+func StartZombieReaper() {
+	if os.Getpid() != 1 {
+		return
+	}
+	Debug("running as pid 1, reaping zombies")
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGCHLD)
+	go func() {
+		for range sigChan {
+			reapZombies(1)
+		}
+	}()
+}
+
+// reapZombies waits for all the zombie processes whose parent is ppid,
+// skipping the children the proxy is waiting for by itself
+func reapZombies(ppid int) int {
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	reaped := 0
+	for _, entry := range procs {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || children[pid] {
+			continue
+		}
+		state, parent, err := procState(pid)
+		if err != nil || state != 'Z' || parent != ppid {
+			continue
+		}
+		var status syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && wpid == pid {
+			Debug("reaped zombie %d", pid)
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// procState reads state and parent pid of a process from /proc/<pid>/stat
+func procState(pid int) (byte, int, error) {
+	buf, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// the command name is in parenthesis and can contain spaces
+	i := bytes.LastIndexByte(buf, ')')
+	if i < 0 || i+2 >= len(buf) {
+		return 0, 0, fmt.Errorf("cannot parse stat of %d", pid)
+	}
+	fields := bytes.Fields(buf[i+2:])
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return 0, 0, fmt.Errorf("cannot parse stat of %d", pid)
+	}
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return fields[0][0], ppid, nil
+}
@@ -0,0 +1,398 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultRecordMaxSize is the size of the recording file, in bytes, when it is rotated
+const DefaultRecordMaxSize = 10 * 1024 * 1024
+
+// DefaultRecordBackups is how many rotated recording files are kept
+const DefaultRecordBackups = 3
+
+// DefaultRecordRedact is the pattern of the names of the fields redacted in the recordings: the whole name
+// is a known secret, optionally after a prefix ending with _ or -, as in db_password or x-api-key
+const DefaultRecordRedact = `(?i)^(.*[_-])?(password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|auth|authorization|credentials?|cookie|set-cookie)$`
+
+// Redacted replaces the values of the redacted fields
+const Redacted = "***"
+
+// maxRecordedLogs is how many bytes of the logs of an activation are recorded, the last ones
+const maxRecordedLogs = 64 * 1024
+
+// recordLogsWait is how long the logs of an activation are waited for
+var recordLogsWait = time.Second
+
+// Recording is an activation recorded in the file OW_RECORD_FILE, one per line
+type Recording struct {
+	// Time is when the activation started
+	Time time.Time `json:"time"`
+	// Duration of the activation, in milliseconds
+	Duration int64 `json:"duration"`
+	// Request is the body of the run request
+	Request json.RawMessage `json:"request"`
+	// RequestBase64 is set when the request is not UTF-8 text, recorded as a base64 string
+	RequestBase64 bool `json:"request_base64,omitempty"`
+	// Context is the activation context sent to the action
+	Context ActivationContext `json:"context"`
+	// Status of the response
+	Status int `json:"status"`
+	// Response is the body of the response, as a string if it is not JSON
+	Response json.RawMessage `json:"response"`
+	// ResponseBase64 is set when the response is not UTF-8 text, recorded as a base64 string
+	ResponseBase64 bool `json:"response_base64,omitempty"`
+	// Streamed is set when the response was streamed, and it is the text of the stream
+	Streamed bool `json:"streamed,omitempty"`
+	// Stdout and Stderr are the logs of the action in the activation
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// recorder writes the activations in a rotating JSONL file
+type recorder struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	backups int
+	redact  redactor
+	stdout  *logTee
+	stderr  *logTee
+}
+
+// newRecorder opens the recording file of the configuration, if any,
+// and collects the logs of the action relaying them to outFile and errFile:
+// it returns the files where the action must write its logs
+func newRecorder(c *Config, outFile *os.File, errFile *os.File) (*recorder, *os.File, *os.File, error) {
+	if c.RecordFile == "" {
+		return nil, outFile, errFile, nil
+	}
+	redact, err := newRedactor(c.RecordRedact)
+	if err != nil {
+		return nil, outFile, errFile, err
+	}
+	rec := &recorder{path: c.RecordFile, maxSize: c.RecordMaxSize, backups: c.RecordBackups, redact: redact}
+	if err := rec.open(); err != nil {
+		return nil, outFile, errFile, err
+	}
+	stdout, stdoutTee, err := newLogTee(outFile)
+	if err != nil {
+		return nil, outFile, errFile, err
+	}
+	stderr, stderrTee, err := newLogTee(errFile)
+	if err != nil {
+		return nil, outFile, errFile, err
+	}
+	rec.stdout, rec.stderr = stdoutTee, stderrTee
+	return rec, stdout, stderr, nil
+}
+
+// open opens the recording file to append to it
+func (rec *recorder) open() error {
+	file, err := os.OpenFile(rec.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rec.file, rec.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the recording file as the first backup, shifting the others, and opens a new one
+func (rec *recorder) rotate() error {
+	rec.file.Close()
+	if rec.backups == 0 {
+		os.Remove(rec.path)
+	}
+	for i := rec.backups; i > 0; i-- {
+		from := rec.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", rec.path, i-1)
+		}
+		os.Rename(from, fmt.Sprintf("%s.%d", rec.path, i))
+	}
+	return rec.open()
+}
+
+// write appends a recording to the file, rotating it when it is full
+func (rec *recorder) write(recording *Recording) {
+	line, err := json.Marshal(recording)
+	if err != nil {
+		Debug("cannot record the activation: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.maxSize > 0 && rec.size > 0 && rec.size+int64(len(line)) > rec.maxSize {
+		if err := rec.rotate(); err != nil {
+			Debug("cannot rotate the recording: %v", err)
+			return
+		}
+	}
+	n, err := rec.file.Write(line)
+	rec.size += int64(n)
+	if err != nil {
+		Debug("cannot record the activation: %v", err)
+	}
+}
+
+// recordingWriter keeps the status and the body of a response
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(buf []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(buf)
+	return w.ResponseWriter.Write(buf)
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// record serves a run request with run, then records it with the logs of the action
+func (rec *recorder) record(w http.ResponseWriter, r *http.Request, run http.HandlerFunc) {
+	var body bytes.Buffer
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, &body), r.Body}
+	rw := &recordingWriter{ResponseWriter: w}
+	stdoutSeen, stderrSeen := rec.stdout.mark(), rec.stderr.mark()
+	start := time.Now()
+	run(rw, r)
+
+	request := bytes.TrimSpace(body.Bytes())
+	recording := &Recording{
+		Time:     start,
+		Duration: time.Since(start).Milliseconds(),
+		Context:  requestContext(request, r.Header),
+		Status:   rw.status,
+	}
+	recording.Request, recording.RequestBase64 = rec.redact.json(request)
+	recording.Response, recording.ResponseBase64 = rec.redact.json(bytes.TrimSpace(rw.body.Bytes()))
+	for name := range recording.Context.Headers {
+		if rec.redact.match(name) {
+			recording.Context.Headers[name] = Redacted
+		}
+	}
+	switch rw.Header().Get("Content-Type") {
+	case EventStreamContentType, NdjsonContentType:
+		recording.Streamed = true
+	}
+	// the logs of the action end with the guards written after the run, if the action ran
+	go func() {
+		recording.Stdout = rec.stdout.logsAfter(stdoutSeen, recordLogsWait)
+		recording.Stderr = rec.stderr.logsAfter(stderrSeen, recordLogsWait)
+		rec.write(recording)
+	}()
+}
+
+// requestContext is the activation context of a run request, as the proxy sends it to the action
+func requestContext(body []byte, header http.Header) ActivationContext {
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+	var encoded string
+	if json.Unmarshal(fields[ActivationContextKey], &encoded) == nil {
+		var ctx ActivationContext
+		if json.Unmarshal([]byte(encoded), &ctx) == nil {
+			return ctx
+		}
+	}
+	return newActivationContext(fields, header)
+}
+
+// redactor redacts the fields with the names matching its patterns
+type redactor []*regexp.Regexp
+
+// newRedactor compiles the comma separated patterns of OW_RECORD_REDACT, DefaultRecordRedact by default
+func newRedactor(patterns string) (redactor, error) {
+	var redact redactor
+	for _, pattern := range strings.Split(patterns, ",") {
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid record_redact: %v", err)
+		}
+		redact = append(redact, re)
+	}
+	return redact, nil
+}
+
+// match checks if a field must be redacted
+func (redact redactor) match(name string) bool {
+	for _, re := range redact {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// json redacts the fields of a JSON document; anything else is returned as a JSON string,
+// encoded in base64 if it is not UTF-8 text, as told by the second result
+func (redact redactor) json(data []byte) (json.RawMessage, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		if !utf8.Valid(data) {
+			buf, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+			return buf, true
+		}
+		buf, _ := json.Marshal(string(data))
+		return buf, false
+	}
+	buf, _ := json.Marshal(redact.value(value))
+	return buf, false
+}
+
+func (redact redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if redact.match(name) {
+				v[name] = Redacted
+			} else {
+				v[name] = redact.value(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact.value(item)
+		}
+	}
+	return value
+}
+
+// logTee relays the logs of the action to a file, keeping those of the last activation,
+// that end with the OutputGuard
+type logTee struct {
+	mu      sync.Mutex
+	current bytes.Buffer
+	last    string
+	guards  int
+	notify  chan struct{}
+}
+
+// newLogTee returns the file where the action writes the logs relayed to dst
+func newLogTee(dst io.Writer) (*os.File, *logTee, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	tee := &logTee{notify: make(chan struct{})}
+	go tee.relay(r, dst)
+	return w, tee, nil
+}
+
+func (t *logTee) relay(r io.ReadCloser, dst io.Writer) {
+	defer r.Close()
+	guard := []byte(OutputGuard)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			dst.Write(buf[:n])
+			t.mu.Lock()
+			t.current.Write(buf[:n])
+			for {
+				data := t.current.Bytes()
+				i := bytes.Index(data, guard)
+				if i < 0 {
+					break
+				}
+				t.last = string(data[:i])
+				rest := append([]byte{}, data[i+len(guard):]...)
+				t.current.Reset()
+				t.current.Write(rest)
+				t.guards++
+				close(t.notify)
+				t.notify = make(chan struct{})
+			}
+			// only the end of long logs is kept
+			if t.current.Len() > maxRecordedLogs {
+				rest := append([]byte{}, t.current.Bytes()[t.current.Len()-maxRecordedLogs:]...)
+				t.current.Reset()
+				t.current.Write(rest)
+			}
+			t.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// mark returns how many activations ended so far
+func (t *logTee) mark() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.guards
+}
+
+// logsAfter waits for the end of an activation after the mark, returning its logs; they are empty
+// if the activation does not end in time
+func (t *logTee) logsAfter(seen int, timeout time.Duration) string {
+	deadline := time.After(timeout)
+	for {
+		t.mu.Lock()
+		guards, last, notify := t.guards, t.last, t.notify
+		t.mu.Unlock()
+		if guards > seen {
+			return last
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			return ""
+		}
+	}
+}
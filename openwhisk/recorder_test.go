@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readRecordings reads the recordings in a file
+func readRecordings(file string) []Recording {
+	recordings := []Recording{}
+	data, _ := os.ReadFile(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var rec Recording
+		if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+			recordings = append(recordings, rec)
+		}
+	}
+	return recordings
+}
+
+// redactJSON redacts a document, expecting it is UTF-8 text
+func redactJSON(t *testing.T, redact redactor, data string) string {
+	buf, encoded := redact.json([]byte(data))
+	assert.False(t, encoded)
+	return string(buf)
+}
+
+func TestRedactor(t *testing.T) {
+	redact, err := newRedactor(DefaultRecordRedact)
+	assert.NoError(t, err)
+	assert.JSONEq(t,
+		`{"value":{"name":"Mike","password":"***","nested":[{"api_key":"***","n":1.50}]},"Authorization":"***"}`,
+		redactJSON(t, redact, `{"value":{"name":"Mike","password":"s3cr3t","nested":[{"api_key":"k","n":1.50}]},"Authorization":"Basic x"}`))
+	// only the known secrets are redacted by default, not the names containing them
+	assert.JSONEq(t,
+		`{"db_password":"***","x-api-key":"***","GITHUB_TOKEN":"***","cookie":"***","monkey":"m","keyboard":"k","author":"a","tokens":2}`,
+		redactJSON(t, redact, `{"db_password":"p","x-api-key":"k","GITHUB_TOKEN":"t","cookie":"c","monkey":"m","keyboard":"k","author":"a","tokens":2}`))
+	// numbers are kept as they are
+	assert.Equal(t, `{"n":12345678901234567890}`, redactJSON(t, redact, `{"n":12345678901234567890}`))
+	// anything else is a string, in base64 if it is not UTF-8
+	assert.Equal(t, `"a,b\n1,2"`, redactJSON(t, redact, "a,b\n1,2"))
+	assert.Equal(t, `""`, redactJSON(t, redact, ""))
+	buf, encoded := redact.json([]byte{0x89, 'P', 'N', 'G', 0xff})
+	assert.True(t, encoded)
+	assert.Equal(t, `"iVBOR/8="`, string(buf))
+
+	redact, err = newRedactor("^name$, (?i)^email")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"***","Email":"***","password":"p","names":"x"}`,
+		redactJSON(t, redact, `{"name":"Mike","Email":"m@example.com","password":"p","names":"x"}`))
+
+	_, err = newRedactor("(")
+	assert.Error(t, err)
+}
+
+func TestRecorder_rotate(t *testing.T) {
+	file := t.TempDir() + "/activations.jsonl"
+	config := &Config{RecordFile: file, RecordMaxSize: 400, RecordBackups: 2, RecordRedact: DefaultRecordRedact}
+	rec, stdout, stderr, err := newRecorder(config, os.Stdout, os.Stderr)
+	assert.NoError(t, err)
+	defer stdout.Close()
+	defer stderr.Close()
+	for i := 0; i < 10; i++ {
+		rec.write(&Recording{Request: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)), Response: json.RawMessage(`{}`), Status: 200})
+	}
+	for _, name := range []string{file, file + ".1", file + ".2"} {
+		info, err := os.Stat(name)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(400))
+	}
+	assert.NoFileExists(t, file+".3")
+	last := readRecordings(file)
+	assert.Equal(t, `{"n":9}`, string(last[len(last)-1].Request))
+}
+
+func TestLogTee(t *testing.T) {
+	var dst bytes.Buffer
+	w, tee, err := newLogTee(&dst)
+	assert.NoError(t, err)
+	seen := tee.mark()
+	w.Write([]byte("hello\nwor"))
+	w.Write([]byte("ld\n" + OutputGuard[:10]))
+	w.Write([]byte(OutputGuard[10:] + "next"))
+	assert.Equal(t, "hello\nworld\n", tee.logsAfter(seen, time.Second))
+	seen = tee.mark()
+	assert.Equal(t, "", tee.logsAfter(seen, 100*time.Millisecond))
+	w.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "hello\nworld\n"+OutputGuard+"next", dst.String())
+}
+
+func TestRecorder_run(t *testing.T) {
+	file := t.TempDir() + "/activations.jsonl"
+	t.Setenv("OW_RECORD_FILE", file)
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary("_test/loop", ""))
+	doContextRun(ts, `{"value":{"name":"Mike","password":"s3cr3t"},"activation_id":"a1"}`)
+	res, status, _ := doPost(ts.URL+"/run", `{"value":{}}`)
+	stopTestServer(ts, cur, log)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"error":"missing name"}`, strings.TrimSpace(res))
+
+	var recordings []Recording
+	assert.Eventually(t, func() bool {
+		recordings = readRecordings(file)
+		return len(recordings) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	first := recordings[0]
+	assert.Equal(t, http.StatusOK, first.Status)
+	assert.JSONEq(t, `{"value":{"name":"Mike","password":"***"},"activation_id":"a1"}`, string(first.Request))
+	assert.JSONEq(t, `{"hello":"Mike"}`, string(first.Response))
+	assert.Equal(t, "a1", first.Context.ActivationID)
+	assert.Equal(t, map[string]string{"x-request-id": "req-1"}, first.Context.Headers)
+	assert.Equal(t, "activation=a1\n", first.Stdout)
+	assert.False(t, first.Time.IsZero())
+	assert.JSONEq(t, `{"error":"missing name"}`, string(recordings[1].Response))
+	assert.Equal(t, "", recordings[1].Stdout)
+}
+
+func TestReplayLocal(t *testing.T) {
+	recording := strings.Join([]string{
+		`{"request":{"value":{"name":"Mike"}},"context":{"version":1,"activation_id":"a1"},"status":200,"response":{"hello":"Mike"}}`,
+		`{"request":{"value":{"name":"Joe"}},"context":{"version":1,"activation_id":"a2"},"status":200,"response":{"hello":"Jim"}}`,
+		`{"request":{"value":{}},"context":{"version":1},"status":200,"response":"data: {}","streamed":true}`,
+		`{"request":{"value":{}},"context":{"version":1},"status":200,"response":{"error":"missing name"}}`,
+	}, "\n")
+	config := testConfig(t.TempDir(), "", ProxyModeNone)
+	config.ActivationContext = true
+	ap := NewActionProxy(config, os.Stdout, os.Stderr)
+	var out, logs bytes.Buffer
+	err := ap.ReplayLocal("_test/loop", "main", nil, strings.NewReader(recording), &out, &logs)
+	assert.EqualError(t, err, "1 of 4 activations are different")
+	assert.Equal(t, `activation 1 a1: same
+activation 2 a2: different
+  recorded: {"hello":"Jim"}
+  replayed: {"hello":"Joe"}
+activation 3: skipped, the response was streamed
+activation 4: same
+`, out.String())
+	assert.Contains(t, logs.String(), "--- activation 1 a1 stdout\nactivation=a1\n")
+
+	out.Reset()
+	err = ap.ReplayLocal("_test/loop", "main", nil, strings.NewReader("{"), &out, &logs)
+	assert.EqualError(t, err, "cannot decode recording 1: unexpected EOF")
+}
+
+func TestReplayBody(t *testing.T) {
+	rec := Recording{Request: json.RawMessage(`{"value":{"name":"Mike"}}`)}
+	assert.Equal(t, `{"value":{"name":"Mike"}}`, string(replayBody(&rec, false)))
+	// a request that is not UTF-8 is replayed as it was
+	rec = Recording{Request: json.RawMessage(`"iVBOR/8="`), RequestBase64: true}
+	assert.Equal(t, []byte{0x89, 'P', 'N', 'G', 0xff}, replayBody(&rec, true))
+}
@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ReplayLocal initializes the action in path as RunLocal does, then runs it again with the requests
+// and the activation contexts of a recording, written with OW_RECORD_FILE. It writes to out
+// if each result is the same as the recorded one, and the logs of each activation to logs.
+// It fails if the action cannot be initialized or if any result is different.
+func (ap *ActionProxy) ReplayLocal(path string, main string, env map[string]interface{}, recording io.Reader, out io.Writer, logs io.Writer) error {
+	redact, err := newRedactor(ap.config.RecordRedact)
+	if err != nil {
+		return err
+	}
+	return ap.runLocalWith(path, main, env, logs, func(executor *Executor, actionLogs *localLogs) error {
+		different := 0
+		decoder := json.NewDecoder(recording)
+		n := 0
+		for {
+			var rec Recording
+			if err := decoder.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("cannot decode recording %d: %v", n+1, err)
+			}
+			n++
+			name := fmt.Sprintf("activation %d", n)
+			if rec.Context.ActivationID != "" {
+				name += " " + rec.Context.ActivationID
+			}
+			if rec.Streamed {
+				fmt.Fprintf(out, "%s: skipped, the response was streamed\n", name)
+				continue
+			}
+
+			// the result is compared as the proxy would send and record it
+			response, runErr := executor.Interact(replayBody(&rec, ap.config.ActivationContext))
+			actionLogs.flush(name)
+			if runErr != nil {
+				response, _ = json.Marshal(ErrResponse{Error: runErrorMessage(runErr)})
+			} else if _, raw, ok, err := parseResultEnvelope(response); ok && err == nil {
+				response = raw
+			}
+			replayed, replayedBase64 := redact.json(bytes.TrimSpace(response))
+			if rec.ResponseBase64 == replayedBase64 && sameJSON(rec.Response, replayed) {
+				fmt.Fprintf(out, "%s: same\n", name)
+			} else {
+				different++
+				fmt.Fprintf(out, "%s: different\n  recorded: %s\n  replayed: %s\n", name, rec.Response, replayed)
+			}
+			if runErr != nil {
+				return fmt.Errorf("%s: %s", name, runErrorMessage(runErr))
+			}
+		}
+		if different > 0 {
+			return fmt.Errorf("%d of %d activations are different", different, n)
+		}
+		return nil
+	})
+}
+
+// replayBody is the body of a recorded run request, with its activation context if enabled
+func replayBody(rec *Recording, withContext bool) []byte {
+	if rec.RequestBase64 {
+		var encoded string
+		json.Unmarshal(rec.Request, &encoded)
+		body, _ := base64.StdEncoding.DecodeString(encoded)
+		return body
+	}
+	if !withContext {
+		return rec.Request
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Request, &fields); err != nil || fields == nil {
+		return rec.Request
+	}
+	fields[ActivationContextKey], _ = json.Marshal(rec.Context.encode())
+	body, _ := json.Marshal(fields)
+	return body
+}
+
+// sameJSON checks if two JSON documents have the same value
+func sameJSON(a, b []byte) bool {
+	var va, vb interface{}
+	da, db := json.NewDecoder(bytes.NewReader(a)), json.NewDecoder(bytes.NewReader(b))
+	da.UseNumber()
+	db.UseNumber()
+	if da.Decode(&va) != nil || db.Decode(&vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
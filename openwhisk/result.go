@@ -0,0 +1,78 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ResultEnvelopeKey is the key of the result an action returns to answer with a body that is not JSON:
+// {"__ow_result": {"content_type": "image/png", "body": "<base64>", "encoding": "base64"}}
+const ResultEnvelopeKey = "__ow_result"
+
+// DefaultResultContentType is used when the envelope does not specify a content type
+const DefaultResultContentType = "application/octet-stream"
+
+// ResultEnvelope is a result with an arbitrary content type
+type ResultEnvelope struct {
+	// ContentType of the body, application/octet-stream if empty
+	ContentType string `json:"content_type,omitempty"`
+	// Body is the text of the result, or its base64 encoding
+	Body string `json:"body"`
+	// Encoding is "base64" when the body is encoded, empty for plain text
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// parseResultEnvelope checks if the result of an action is an envelope,
+// returning its content type and the decoded body
+func parseResultEnvelope(response []byte) (string, []byte, bool, error) {
+	if !bytes.Contains(response, []byte(`"`+ResultEnvelopeKey+`"`)) {
+		return "", nil, false, nil
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(response, &msg); err != nil || len(msg) != 1 {
+		return "", nil, false, nil
+	}
+	raw, ok := msg[ResultEnvelopeKey]
+	if !ok {
+		return "", nil, false, nil
+	}
+	var envelope ResultEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return "", nil, true, fmt.Errorf("invalid result envelope: %v", err)
+	}
+	contentType := envelope.ContentType
+	if contentType == "" {
+		contentType = DefaultResultContentType
+	}
+	switch envelope.Encoding {
+	case "":
+		return contentType, []byte(envelope.Body), true, nil
+	case "base64":
+		body, err := base64.StdEncoding.DecodeString(envelope.Body)
+		if err != nil {
+			return "", nil, true, fmt.Errorf("invalid result envelope: %v", err)
+		}
+		return contentType, body, true, nil
+	default:
+		return "", nil, true, fmt.Errorf("invalid result envelope: unknown encoding %q", envelope.Encoding)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func doResultRun(ts *httptest.Server, kind string) {
+	res, err := http.Post(ts.URL+"/run", "application/json", bytes.NewBufferString(`{"value":{"kind":"`+kind+`"}}`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	fmt.Printf("%d %s %q\n", res.StatusCode, res.Header.Get("Content-Type"), body)
+}
+
+func TestParseResultEnvelope(t *testing.T) {
+	ct, body, ok, err := parseResultEnvelope([]byte(`{"__ow_result": {"content_type": "text/plain", "body": "hello"}}`))
+	require.True(t, ok)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", ct)
+	require.Equal(t, "hello", string(body))
+
+	ct, body, ok, err = parseResultEnvelope([]byte(`{"__ow_result": {"body": "AAEC", "encoding": "base64"}}`))
+	require.True(t, ok)
+	require.NoError(t, err)
+	require.Equal(t, DefaultResultContentType, ct)
+	require.Equal(t, []byte{0, 1, 2}, body)
+
+	_, _, ok, err = parseResultEnvelope([]byte(`{"__ow_result": {"body": "!!", "encoding": "base64"}}`))
+	require.True(t, ok)
+	require.Error(t, err)
+
+	// ordinary results are left alone
+	_, _, ok, _ = parseResultEnvelope([]byte(`{"__ow_result": {}, "other": 1}`))
+	require.False(t, ok)
+	_, _, ok, _ = parseResultEnvelope([]byte(`{"body": "__ow_result"}`))
+	require.False(t, ok)
+	_, _, ok, _ = parseResultEnvelope([]byte(`[1, 2]`))
+	require.False(t, ok)
+}
+
+func Example_resultEnvelope() {
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary("_test/result.sh", ""))
+	doResultRun(ts, "json")
+	doResultRun(ts, "csv")
+	doResultRun(ts, "bin")
+	doResultRun(ts, "bad")
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 application/json "{\"kind\": \"json\"}\n"
+	// 200 text/csv "a,b\n1,2\n"
+	// 200 application/octet-stream "\x00\x01\x02\xff"
+	// 502 application/json "{\"error\":\"The action returned an invalid result envelope: unknown encoding \\\"rot13\\\"\"}\n"
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_resultEnvelopeForwarded() {
+	clientLog, _ := os.CreateTemp("", "log")
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), clientLog, clientLog)
+	serverLog, _ := os.CreateTemp("", "log")
+	serverAP := NewActionProxy(testConfig("./action", "", ProxyModeServer), serverLog, serverLog)
+	server := httptest.NewServer(serverAP)
+	client := httptest.NewServer(clientAP)
+
+	doInit(client, initBinary("_test/result.sh", "@"+server.URL))
+	doResultRun(client, "json")
+	doResultRun(client, "csv")
+	doResultRun(client, "bin")
+
+	client.Close()
+	server.Close()
+	os.Remove(serverLog.Name())
+	os.Remove(clientLog.Name())
+	// Output:
+	// 200 {"ok":true}
+	// 200 application/json "{\"kind\":\"json\"}"
+	// 200 text/csv "a,b\n1,2\n"
+	// 200 application/octet-stream "\x00\x01\x02\xff"
+}
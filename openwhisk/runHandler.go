@@ -19,7 +19,9 @@ package openwhisk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -29,16 +31,20 @@ import (
 type runRequest struct {
 	ActionCodeHash string                 `json:"actionCodeHash,omitempty"`
 	Value          map[string]interface{} `json:"value,omitempty"`
+	Context        string                 `json:"context,omitempty"`
 }
 
 // ErrResponse is the response when there are errors
 type ErrResponse struct {
-	Error string `json:"error"`
+	Error       string       `json:"error"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
 }
 
 type remoteRunChanPayload struct {
+	ctx        context.Context
 	runRequest *runRequest
 	respChan   chan *ServerRunResponseChanPayload
+	onChunk    func([]byte) error
 }
 type ServerRunResponseChanPayload struct {
 	runResp *RemoteRunResponse
@@ -53,7 +59,10 @@ type RemoteRunResponse struct {
 }
 
 func sendError(w http.ResponseWriter, code int, cause string) {
-	errResponse := ErrResponse{Error: cause}
+	sendErrorResponse(w, code, ErrResponse{Error: cause})
+}
+
+func sendErrorResponse(w http.ResponseWriter, code int, errResponse ErrResponse) {
 	b, err := json.Marshal(errResponse)
 	if err != nil {
 		b = []byte("error marshalling error response")
@@ -66,7 +75,15 @@ func sendError(w http.ResponseWriter, code int, cause string) {
 }
 
 func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := ap.tracer.startSpan(r.Context(), "run", spanKindServer, r.Header)
+	defer span.end()
+	r = r.WithContext(ctx)
+
 	if ap.proxyMode == ProxyModeNone {
+		if ap.recorder != nil {
+			ap.recorder.record(w, r, ap.doRun)
+			return
+		}
 		ap.doRun(w, r)
 		return
 	}
@@ -96,9 +113,13 @@ func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
 			sendError(w, http.StatusBadRequest, "Action code hash not provided from client")
 			return
 		}
+		// the client proxy sends the activation context, build it only if missing
+		if ap.config.ActivationContext && runRequest.Context == "" {
+			runRequest.Context = newActivationContext(nil, r.Header).encode()
+		}
 		innerActionProxy, ok := ap.serverProxyData.actions[runRequest.ActionCodeHash]
 		if !ok {
-			Debug("Action hash %s not found in server proxy data", runRequest.ActionCodeHash)
+			ap.logger.Warn("the action is not initialized", "code_hash", runRequest.ActionCodeHash)
 			sendError(w, http.StatusNotFound, "Action not found in remote runtime. Check logs for details.")
 			return
 		}
@@ -106,9 +127,37 @@ func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
 		// Enqueue the request to be processed by the inner proxy one at a time
 		responseChan := make(chan *ServerRunResponseChanPayload)
 
-		innerActionProxy.runRequestQueue <- &remoteRunChanPayload{runRequest: &runRequest, respChan: responseChan}
+		// if the action streams its response, relay the chunks to the client proxy
+		var hop *streamWriter
+		onChunk := func(chunk []byte) error {
+			if hop == nil {
+				hop = newStreamWriter(w, "")
+				w.Header().Set("Content-Type", hopStreamContentType)
+				w.WriteHeader(http.StatusOK)
+			}
+			buf, err := json.Marshal(hopChunk{Chunk: chunk})
+			if err != nil {
+				return err
+			}
+			return hop.chunk(buf)
+		}
+
+		innerActionProxy.runRequestQueue <- &remoteRunChanPayload{ctx: ctx, runRequest: &runRequest, respChan: responseChan, onChunk: onChunk}
 
 		res := <-responseChan
+		span.setError(res.err)
+		if hop != nil {
+			// the last line of the stream is the response or the error
+			if res.err != nil {
+				hop.fail(res.err.Error())
+			} else if buf, err := json.Marshal(res.runResp); err == nil {
+				hop.end(buf)
+			} else {
+				hop.fail(fmt.Sprintf("Error marshalling response: %v", err))
+			}
+			close(responseChan)
+			return
+		}
 		if res.err != nil {
 			sendError(w, res.status, res.err.Error())
 			return
@@ -150,26 +199,36 @@ func (ap *ActionProxy) runHandler(w http.ResponseWriter, r *http.Request) {
 
 func startListenToRunRequests(ap *ActionProxy, runRequestQueue chan *remoteRunChanPayload) {
 	for runReq := range runRequestQueue {
-		remoteResponse, status, err := ap.doServerModeRun(runReq.runRequest)
+		remoteResponse, status, err := ap.doServerModeRun(runReq.ctx, runReq.runRequest, runReq.onChunk)
 		runReq.respChan <- &ServerRunResponseChanPayload{runResp: &remoteResponse, status: status, err: err}
 	}
 }
 
-func (ap *ActionProxy) doServerModeRun(bodyRequest *runRequest) (RemoteRunResponse, int, error) {
+func (ap *ActionProxy) doServerModeRun(ctx context.Context, bodyRequest *runRequest, onChunk func([]byte) error) (RemoteRunResponse, int, error) {
 	Debug("Executing run request in server mode")
-	body, status, err := prepareRemoteRunBody(ap, bodyRequest)
+	version := ap.versions.acquire()
+	if version != nil {
+		defer version.release()
+	}
+	_, interact := ap.tracer.startChildSpan(ctx, "interact")
+	defer interact.end()
+	bodyRequest.Context = withContextTraceparent(bodyRequest.Context, interact)
+	body, status, err := prepareRemoteRunBody(version, bodyRequest)
 	if err != nil {
+		interact.setError(err)
 		return RemoteRunResponse{}, status, err
 	}
 
 	// execute the action
-	response, err := ap.theExecutor.Interact(body)
+	interact.setAttribute("action.version", version.Number)
+	response, err := version.executor.InteractStream(body, onChunk)
+	interact.setError(err)
 
 	// check for early termination
 	if err != nil {
-		Debug("WARNING! Command exited")
-		ap.theExecutor = nil
-		return RemoteRunResponse{}, http.StatusBadRequest, fmt.Errorf("command exited")
+		ap.activationLogger(body).Warn("the action exited", "version", version.Number, "error", err)
+		ap.versions.fail(version)
+		return RemoteRunResponse{}, http.StatusBadRequest, fmt.Errorf("%s", runErrorMessage(err))
 	}
 	DebugLimit("received (remote): ", response, 120)
 
@@ -213,38 +272,78 @@ func (ap *ActionProxy) doRun(w http.ResponseWriter, r *http.Request) {
 
 	body = bytes.Replace(body, []byte("\n"), []byte(""), -1)
 
-	// check if you have an action
-	if ap.theExecutor == nil {
+	// check if you have an action, running it with the current version until the end
+	version := ap.versions.acquire()
+	if version == nil {
 		sendError(w, http.StatusInternalServerError, "no action defined yet")
 		return
 	}
+	defer version.release()
+	_, interact := ap.tracer.startChildSpan(r.Context(), "interact")
+	defer interact.end()
+	interact.setAttribute("action.version", version.Number)
+	if ap.config.ActivationContext {
+		body = withActivationContext(body, withTraceparent(r.Header, interact))
+	}
 
 	// check if the process exited
-	if ap.theExecutor.Exited() {
+	if version.executor.Exited() {
 		sendError(w, http.StatusInternalServerError, "command exited")
 		return
 	}
 
-	// execute the action
-	response, err := ap.theExecutor.Interact(body)
+	// execute the action, relaying the chunks if it streams its response
+	var stream *streamWriter
+	response, err := version.executor.InteractStream(body, func(chunk []byte) error {
+		if stream == nil {
+			stream = newStreamWriter(w, r.Header.Get("Accept"))
+			stream.start(w)
+		}
+		return stream.chunk(chunk)
+	})
+	interact.setError(err)
 
 	// check for early termination
 	if err != nil {
-		Debug("WARNING! Command exited")
-		ap.theExecutor = nil
-		sendError(w, http.StatusBadRequest, "command exited")
+		ap.activationLogger(body).Warn("the action exited", "version", version.Number, "error", err)
+		ap.versions.fail(version)
+		if stream != nil {
+			stream.fail(runErrorMessage(err))
+			return
+		}
+		sendError(w, http.StatusBadRequest, runErrorMessage(err))
 		return
 	}
 	DebugLimit("received:", response, 120)
 
 	// check if the answer is an object map or array
 	if ok := isJsonObjOrArray(response); !ok {
+		if stream != nil {
+			stream.fail("The action did not return a dictionary or array.")
+			return
+		}
 		sendError(w, http.StatusBadGateway, "The action did not return a dictionary or array.")
 		return
 	}
 
+	// the status is already sent, the result closes the stream
+	if stream != nil {
+		stream.end(response)
+		return
+	}
+
+	// an envelope carries a result of any content type
+	contentType := "application/json"
+	if ct, raw, ok, err := parseResultEnvelope(response); ok {
+		if err != nil {
+			sendError(w, http.StatusBadGateway, fmt.Sprintf("The action returned an %v", err))
+			return
+		}
+		contentType, response = ct, raw
+	}
+
 	// write response
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(response)))
 	numBytesWritten, err := w.Write(response)
 
@@ -266,6 +365,15 @@ func (ap *ActionProxy) doRun(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// runErrorMessage describes a failed run, telling if the action breached a limit
+func runErrorMessage(err error) string {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.Error()
+	}
+	return "command exited"
+}
+
 func isJsonObjOrArray(response []byte) bool {
 	var objmap map[string]*json.RawMessage
 	var objarray []interface{}
@@ -279,7 +387,7 @@ func isJsonObjOrArray(response []byte) bool {
 	return true
 }
 
-func prepareRemoteRunBody(ap *ActionProxy, bodyRequest *runRequest) ([]byte, int, error) {
+func prepareRemoteRunBody(version *ActionVersion, bodyRequest *runRequest) ([]byte, int, error) {
 	var bodyBuf bytes.Buffer
 	err := json.NewEncoder(&bodyBuf).Encode(bodyRequest)
 	if err != nil {
@@ -288,12 +396,12 @@ func prepareRemoteRunBody(ap *ActionProxy, bodyRequest *runRequest) ([]byte, int
 	body := bytes.Replace(bodyBuf.Bytes(), []byte("\n"), []byte(""), -1)
 
 	// check if you have an action
-	if ap.theExecutor == nil {
+	if version == nil {
 		return nil, http.StatusInternalServerError, fmt.Errorf("no action defined yet")
 	}
 
 	// check if the process exited
-	if ap.theExecutor.Exited() {
+	if version.executor.Exited() {
 		return nil, http.StatusInternalServerError, fmt.Errorf("command exited")
 	}
 
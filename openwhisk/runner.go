@@ -0,0 +1,169 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RunLocal initializes the action in path, a file, an archive or a directory, then runs it
+// with each JSON value read from inputs as the payload of an activation, without a server.
+// The results are written to out, one per line, and the logs of each activation to logs.
+// It fails if the action cannot be initialized or if any activation fails.
+func (ap *ActionProxy) RunLocal(path string, main string, env map[string]interface{}, inputs io.Reader, out io.Writer, logs io.Writer) error {
+	return ap.runLocalWith(path, main, env, logs, func(executor *Executor, actionLogs *localLogs) error {
+		// run it with every input
+		failed := 0
+		decoder := json.NewDecoder(inputs)
+		for n := 1; ; n++ {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("cannot decode input %d: %v", n, err)
+			}
+			var body bytes.Buffer
+			body.WriteString(`{"value":`)
+			json.Compact(&body, value)
+			body.WriteString(`}`)
+			request := body.Bytes()
+			if ap.config.ActivationContext {
+				request = withActivationContext(request, nil)
+			}
+			response, err := executor.InteractStream(request, func(chunk []byte) error {
+				_, err := fmt.Fprintf(out, "%s\n", chunk)
+				return err
+			})
+			actionLogs.flush(fmt.Sprintf("activation %d", n))
+			if err != nil {
+				return fmt.Errorf("activation %d: %s", n, runErrorMessage(err))
+			}
+			fmt.Fprintf(out, "%s\n", bytes.TrimSpace(response))
+			if !isJsonObjOrArray(response) || hasError(response) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d activations failed", failed)
+		}
+		return nil
+	})
+}
+
+// runLocalWith initializes the action in path as RunLocal does, then runs it with run,
+// that gets the executor of the action and its logs
+func (ap *ActionProxy) runLocalWith(path string, main string, env map[string]interface{}, logs io.Writer, run func(*Executor, *localLogs) error) error {
+	// the logs of the action are collected in temporary files, to separate them by activation
+	outLog, err := os.CreateTemp("", "out-log")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(outLog.Name())
+	defer outLog.Close()
+	errLog, err := os.CreateTemp("", "err-log")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(errLog.Name())
+	defer errLog.Close()
+	ap.outFile, ap.errFile = outLog, errLog
+	actionLogs := &localLogs{w: logs, out: outLog, err: errLog}
+
+	// initialize the action
+	src, err := openLocalAction(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if main == "" {
+		main = "main"
+	}
+	ap.SetEnv(env)
+	if _, err := ap.ExtractAndCompileFrom(src, main); err != nil {
+		return fmt.Errorf("cannot initialize the action: %v", err)
+	}
+	err = ap.StartLatestAction()
+	actionLogs.flush("init")
+	if err != nil {
+		return fmt.Errorf("cannot start the action: %v", err)
+	}
+	defer ap.versions.stop()
+	return run(ap.versions.executor(), actionLogs)
+}
+
+// openLocalAction opens an action to initialize: a file as it is, a directory zipped
+func openLocalAction(path string) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.Open(path)
+	}
+	buf, err := Zip(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+// hasError checks if a result is an {"error": ...} object
+func hasError(response []byte) bool {
+	var result map[string]json.RawMessage
+	if json.Unmarshal(response, &result) != nil {
+		return false
+	}
+	_, ok := result["error"]
+	return ok
+}
+
+// localLogs writes the logs of the action collected since the last flush, without the guards
+type localLogs struct {
+	w        io.Writer
+	out, err *os.File
+	outPos   int64
+	errPos   int64
+}
+
+func (l *localLogs) flush(what string) {
+	l.section(what+" stdout", l.out, &l.outPos)
+	l.section(what+" stderr", l.err, &l.errPos)
+}
+
+func (l *localLogs) section(title string, file *os.File, pos *int64) {
+	info, err := file.Stat()
+	if err != nil || info.Size() <= *pos {
+		return
+	}
+	buf := make([]byte, info.Size()-*pos)
+	n, _ := file.ReadAt(buf, *pos)
+	*pos += int64(n)
+	text := strings.ReplaceAll(string(buf[:n]), OutputGuard, "")
+	if text == "" {
+		return
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	fmt.Fprintf(l.w, "--- %s\n%s", title, text)
+}
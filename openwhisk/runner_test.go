@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func runLocal(path string, compiler string, inputs string) (string, string, error) {
+	dir, _ := os.MkdirTemp("", "action")
+	defer os.RemoveAll(dir)
+	ap := NewActionProxy(testConfig(dir, compiler, ProxyModeNone), os.Stdout, os.Stderr)
+	var out, logs bytes.Buffer
+	err := ap.RunLocal(path, "", nil, strings.NewReader(inputs), &out, &logs)
+	return out.String(), logs.String(), err
+}
+
+func TestRunLocal(t *testing.T) {
+	out, logs, err := runLocal("_test/hello.sh", "", `{"name":"Mike"} {"name":"Joe"}`)
+	require.NoError(t, err)
+	require.Equal(t, "{\"hello\": \"Mike\"}\n{\"hello\": \"Joe\"}\n", out)
+	require.Equal(t, "--- activation 1 stdout\nmsg=hello Mike\n--- activation 2 stdout\nmsg=hello Joe\n", logs)
+
+	// a directory is zipped
+	dir := t.TempDir()
+	buf, _ := os.ReadFile("_test/hello.sh")
+	os.WriteFile(filepath.Join(dir, "exec"), buf, 0755)
+	out, _, err = runLocal(dir, "", `{"name":"Dir"}`)
+	require.NoError(t, err)
+	require.Equal(t, "{\"hello\": \"Dir\"}\n", out)
+
+	// failures
+	comp, _ := filepath.Abs("common/gobuild.py")
+	out, _, err = runLocal("_test/typed.src", comp, `{"name":"Mike"} {}`)
+	require.EqualError(t, err, "1 activations failed")
+	require.Equal(t, "{\"greeting\":\"Hello, Mike!\"}\n{\"error\":\"missing name\"}\n", out)
+	out, _, err = runLocal("_test/stream.sh", "", `{"name":"bad"}`)
+	require.EqualError(t, err, "activation 1: command exited")
+	require.Equal(t, "\"partial\"\n", out)
+	_, _, err = runLocal("_test/hello.sh", "", `{"name":`)
+	require.EqualError(t, err, "cannot decode input 1: unexpected EOF")
+	_, _, err = runLocal("_test/badsig.src", comp, `{}`)
+	require.ErrorContains(t, err, "cannot initialize the action: exec__.go:20:6: the action must return a map")
+}
@@ -0,0 +1,294 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// SandboxFlag is the flag of the proxy acting as the sandbox launcher, as <proxy> -sandbox <action> <args>:
+// programs embedding the proxy must call RunSandbox when started with it, as the proxy does in its main
+const SandboxFlag = "-sandbox"
+
+// sandboxEnv passes to the sandbox launcher the rlimits and the hardening to apply
+const sandboxEnv = "__OW_SANDBOX_APPLY"
+
+// ActionSandbox is the hardening applied to an action process.
+// It is configured only by the environment of the proxy, never by the init request.
+type ActionSandbox struct {
+	// Credential is the user and group the action runs as, nil to keep the ones of the proxy
+	Credential *syscall.Credential
+	// NoNewPrivs sets PR_SET_NO_NEW_PRIVS, so the action cannot gain privileges with setuid binaries
+	NoNewPrivs bool
+	// Seccomp applies the default seccomp filter, denying the syscalls an action never needs
+	Seccomp bool
+	// WorkDir gives the action a private working directory, also used as HOME and TMPDIR
+	WorkDir bool
+	// the ids allocated to the action from the ranges, released when it stops
+	allocated []allocatedID
+}
+
+// IsZero checks if no hardening is requested
+func (s ActionSandbox) IsZero() bool {
+	return s.Credential == nil && !s.NoNewPrivs && !s.Seccomp && !s.WorkDir
+}
+
+// newSandbox prepares the hardening of an action configured with OW_ACTION_UID, OW_ACTION_GID and OW_SANDBOX:
+// the ids are single ids or ranges of ids like 10000-10999, where each action gets an id not in use,
+// and the sandbox is "1" for everything or a comma separated list of "nnp", "seccomp" and "workdir".
+// The ids allocated are released by the executor when the action stops.
+func newSandbox(c *Config) (ActionSandbox, error) {
+	sandbox, err := parseSandbox(c.ActionSandbox)
+	if err != nil {
+		return sandbox, err
+	}
+	if c.ActionUID != "" {
+		if os.Geteuid() != 0 {
+			return sandbox, fmt.Errorf("the action_uid requires the proxy to run as root")
+		}
+		uid, err := actionUIDs.alloc(c.ActionUID)
+		if err != nil {
+			return sandbox, fmt.Errorf("invalid action_uid: %v", err)
+		}
+		sandbox.allocated = append(sandbox.allocated, uid)
+		gid := uid.id
+		if c.ActionGID != "" {
+			allocated, err := actionGIDs.alloc(c.ActionGID)
+			if err != nil {
+				sandbox.release()
+				return sandbox, fmt.Errorf("invalid action_gid: %v", err)
+			}
+			sandbox.allocated = append(sandbox.allocated, allocated)
+			gid = allocated.id
+		}
+		sandbox.Credential = &syscall.Credential{Uid: uid.id, Gid: gid, Groups: []uint32{}}
+	}
+	return sandbox, nil
+}
+
+// parseSandbox parses the options of the hardening of the actions, without the ids
+func parseSandbox(options string) (ActionSandbox, error) {
+	var sandbox ActionSandbox
+	for _, opt := range strings.Split(options, ",") {
+		switch strings.TrimSpace(opt) {
+		case "":
+		case "1":
+			sandbox.NoNewPrivs, sandbox.Seccomp, sandbox.WorkDir = true, true, true
+		case "nnp":
+			sandbox.NoNewPrivs = true
+		case "seccomp":
+			// without privileges, a filter can be installed only with no_new_privs
+			sandbox.NoNewPrivs, sandbox.Seccomp = true, true
+		case "workdir":
+			sandbox.WorkDir = true
+		default:
+			return sandbox, fmt.Errorf("invalid action_sandbox option: %q", opt)
+		}
+	}
+	if (sandbox.NoNewPrivs || sandbox.Seccomp) && runtime.GOOS != "linux" {
+		return sandbox, fmt.Errorf("the action_sandbox is supported only on linux")
+	}
+	return sandbox, nil
+}
+
+// checkSandbox checks the hardening of the configuration, without allocating any id
+func checkSandbox(c *Config) error {
+	if _, err := parseSandbox(c.ActionSandbox); err != nil {
+		return err
+	}
+	if c.ActionUID == "" {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("the action_uid requires the proxy to run as root")
+	}
+	if _, _, _, err := parseIDs(c.ActionUID); err != nil {
+		return fmt.Errorf("invalid action_uid: %v", err)
+	}
+	if c.ActionGID == "" {
+		return nil
+	}
+	if _, _, _, err := parseIDs(c.ActionGID); err != nil {
+		return fmt.Errorf("invalid action_gid: %v", err)
+	}
+	return nil
+}
+
+// idPool tracks the ids in use by the actions, so concurrent actions never share an id of a range
+type idPool struct {
+	mu   sync.Mutex
+	used map[uint32]bool
+}
+
+// allocatedID is an id allocated from a pool, with no pool when it is not from a range
+type allocatedID struct {
+	pool *idPool
+	id   uint32
+}
+
+// the pools of the users and of the groups of the actions
+var (
+	actionUIDs = &idPool{used: map[uint32]bool{}}
+	actionGIDs = &idPool{used: map[uint32]bool{}}
+)
+
+// alloc parses an id or a range of ids, allocating the first id of the range not in use.
+// A single id is shared by all the actions.
+func (p *idPool) alloc(ids string) (allocatedID, error) {
+	start, end, isRange, err := parseIDs(ids)
+	if err != nil {
+		return allocatedID{}, err
+	}
+	if !isRange {
+		return allocatedID{id: start}, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := uint64(start); id <= uint64(end); id++ {
+		if !p.used[uint32(id)] {
+			p.used[uint32(id)] = true
+			return allocatedID{p, uint32(id)}, nil
+		}
+	}
+	return allocatedID{}, fmt.Errorf("all the ids in %s are in use", ids)
+}
+
+// parseIDs parses an id, or a range of ids like 10000-10999
+func parseIDs(ids string) (uint32, uint32, bool, error) {
+	first, last, isRange := strings.Cut(ids, "-")
+	start, err := strconv.ParseUint(strings.TrimSpace(first), 10, 32)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if !isRange {
+		return uint32(start), uint32(start), false, nil
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(last), 10, 32)
+	if err != nil || end < start {
+		return 0, 0, false, fmt.Errorf("bad range %s", ids)
+	}
+	return uint32(start), uint32(end), true, nil
+}
+
+// release gives back to their pools the ids allocated to the action
+func (s *ActionSandbox) release() {
+	for _, a := range s.allocated {
+		if a.pool != nil {
+			a.pool.mu.Lock()
+			delete(a.pool.used, a.id)
+			a.pool.mu.Unlock()
+		}
+	}
+	s.allocated = nil
+}
+
+// protectDir makes the directory of an action readable only by the proxy and the group of the action,
+// so the action cannot change it and other actions cannot read it. The files and the directories
+// extracted from the archive get the same owner and lose the write bits of the group and all the bits
+// of the others, so entries archived as world writable cannot be changed by the other ids of the pool.
+func (s ActionSandbox) protectDir(dir string) error {
+	if s.Credential == nil {
+		return nil
+	}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, os.Geteuid(), int(s.Credential.Gid)); err != nil {
+			return err
+		}
+		// the mode of a symbolic link does not matter, and chmod would follow it
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0027)
+	})
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dir, 0750)
+}
+
+// prepare changes the command to run in the sandbox, returning the private working directory if any
+func (s ActionSandbox) prepare(proc *Executor) (string, error) {
+	cmd := proc.cmd
+	if s.Credential != nil {
+		cmd.SysProcAttr.Credential = s.Credential
+	}
+	workDir := ""
+	if s.WorkDir {
+		path, err := filepath.Abs(cmd.Path)
+		if err != nil {
+			return "", err
+		}
+		cmd.Path = path
+		if workDir, err = os.MkdirTemp("", "action-"); err != nil {
+			return "", err
+		}
+		if s.Credential != nil {
+			if err := os.Chown(workDir, int(s.Credential.Uid), int(s.Credential.Gid)); err != nil {
+				os.RemoveAll(workDir)
+				return "", err
+			}
+		}
+		cmd.Dir = workDir
+		cmd.Env = append(cmd.Env, "HOME="+workDir, "TMPDIR="+workDir)
+	}
+	return workDir, nil
+}
+
+// launcherOptions lists the hardening the sandbox launcher applies before executing the action
+func (s ActionSandbox) launcherOptions() []string {
+	opts := []string{}
+	if s.NoNewPrivs {
+		opts = append(opts, "nnp")
+	}
+	if s.Seccomp {
+		opts = append(opts, "seccomp")
+	}
+	return opts
+}
+
+// useLauncher changes the command to run through the sandbox launcher:
+// the proxy itself, invoked with SandboxFlag, applies the options and executes the action
+func useLauncher(cmd *exec.Cmd, opts []string) error {
+	if !childEnabled.Load() {
+		return fmt.Errorf("cannot apply the sandbox: %w", errNoRunChild)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{self, SandboxFlag, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+	cmd.Env = append(cmd.Env, sandboxEnv+"="+strings.Join(opts, ","))
+	return nil
+}
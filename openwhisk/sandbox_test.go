@@ -0,0 +1,218 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSandbox(t *testing.T) {
+	sandbox, err := newSandbox(&Config{})
+	require.NoError(t, err)
+	assert.True(t, sandbox.IsZero())
+
+	sandbox, err = newSandbox(&Config{ActionSandbox: "1"})
+	require.NoError(t, err)
+	assert.Equal(t, ActionSandbox{NoNewPrivs: true, Seccomp: true, WorkDir: true}, sandbox)
+
+	sandbox, err = newSandbox(&Config{ActionSandbox: "workdir, nnp"})
+	require.NoError(t, err)
+	assert.Equal(t, ActionSandbox{NoNewPrivs: true, WorkDir: true}, sandbox)
+
+	_, err = newSandbox(&Config{ActionSandbox: "chroot"})
+	assert.EqualError(t, err, `invalid action_sandbox option: "chroot"`)
+
+	if os.Geteuid() != 0 {
+		t.Skip("uid tests require root")
+	}
+	config := &Config{ActionUID: "10000-10009", ActionGID: "2000"}
+	sandbox, err = newSandbox(config)
+	require.NoError(t, err)
+	defer sandbox.release()
+	assert.Equal(t, uint32(10000), sandbox.Credential.Uid)
+	assert.Equal(t, uint32(2000), sandbox.Credential.Gid)
+	other, err := newSandbox(config)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(10001), other.Credential.Uid)
+	other.release()
+
+	_, err = newSandbox(&Config{ActionUID: "10-1"})
+	assert.EqualError(t, err, "invalid action_uid: bad range 10-1")
+}
+
+func TestCheckSandbox(t *testing.T) {
+	assert.NoError(t, checkSandbox(&Config{ActionSandbox: "nnp,workdir"}))
+	assert.EqualError(t, checkSandbox(&Config{ActionSandbox: "chroot"}), `invalid action_sandbox option: "chroot"`)
+	if os.Geteuid() != 0 {
+		assert.EqualError(t, checkSandbox(&Config{ActionUID: "10000"}), "the action_uid requires the proxy to run as root")
+		return
+	}
+	// the ids are only checked, not allocated
+	assert.NoError(t, checkSandbox(&Config{ActionUID: "10000-10000", ActionGID: "2000"}))
+	assert.NoError(t, checkSandbox(&Config{ActionUID: "10000-10000"}))
+	assert.EqualError(t, checkSandbox(&Config{ActionUID: "10-1"}), "invalid action_uid: bad range 10-1")
+	assert.EqualError(t, checkSandbox(&Config{ActionUID: "10", ActionGID: "x"}), `invalid action_gid: strconv.ParseUint: parsing "x": invalid syntax`)
+}
+
+func TestIDPool(t *testing.T) {
+	pool := &idPool{used: map[uint32]bool{}}
+	a, err := pool.alloc("100-101")
+	require.NoError(t, err)
+	b, err := pool.alloc("100-101")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{100, 101}, []uint32{a.id, b.id})
+	_, err = pool.alloc("100-101")
+	assert.EqualError(t, err, "all the ids in 100-101 are in use")
+
+	// released ids are reused, single ids are shared
+	sandbox := ActionSandbox{allocated: []allocatedID{a}}
+	sandbox.release()
+	c, err := pool.alloc("100-101")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(100), c.id)
+	single, err := pool.alloc("42")
+	require.NoError(t, err)
+	assert.Equal(t, allocatedID{id: 42}, single)
+
+	_, err = pool.alloc("10-1")
+	assert.EqualError(t, err, "bad range 10-1")
+}
+
+// runSandboxed runs a script in the sandbox and returns what it prints in fd3
+func runSandboxed(t *testing.T, sandbox ActionSandbox, script string) (string, *Executor) {
+	log, _ := os.CreateTemp("", "log")
+	t.Cleanup(func() { os.Remove(log.Name()) })
+	proc := NewExecutor(log, log, writeScript(t, script), m)
+	proc.sandbox = sandbox
+	require.NoError(t, proc.Start(false))
+	res, err := proc.Interact([]byte("{}"))
+	require.NoError(t, err)
+	return strings.TrimSpace(string(res)), proc
+}
+
+func TestSandbox_seccomp(t *testing.T) {
+	res, proc := runSandboxed(t, ActionSandbox{NoNewPrivs: true, Seccomp: true},
+		"#!/bin/bash\nwhile read a; do echo $(grep -E '^(NoNewPrivs|Seccomp):' /proc/self/status) >&3; done\n")
+	proc.Stop()
+	assert.Equal(t, "NoNewPrivs: 1 Seccomp: 2", res)
+
+	res, proc = runSandboxed(t, ActionSandbox{NoNewPrivs: true, Seccomp: true},
+		"#!/bin/bash\nwhile read a; do python3 -c 'import os; os.chroot(\"/\")' 2>/dev/null && echo allowed >&3 || echo denied >&3; done\n")
+	proc.Stop()
+	assert.Equal(t, "denied", res)
+
+	// clone creating namespaces is denied, clone3 is not implemented so the libc uses clone
+	res, proc = runSandboxed(t, ActionSandbox{NoNewPrivs: true, Seccomp: true},
+		"#!/bin/bash\nwhile read a; do python3 -c '"+cloneScript+"' >&3; done\n")
+	proc.Stop()
+	assert.Equal(t, "1 38 0", res)
+}
+
+// cloneScript prints the errno of clone with CLONE_NEWNET, of clone3 and of a plain fork
+const cloneScript = `
+import ctypes, os
+libc = ctypes.CDLL(None, use_errno=True)
+clone, clone3 = {"x86_64": (56, 435), "aarch64": (220, 435)}[os.uname().machine]
+res = []
+for nr, flags in [(clone, 0x40000000 | 17), (clone3, 0)]:
+    ctypes.set_errno(0)
+    if libc.syscall(nr, ctypes.c_ulong(flags), 0, 0, 0, 0) == 0:
+        os._exit(0)
+    res.append(ctypes.get_errno())
+pid = os.fork()
+if pid == 0:
+    os._exit(0)
+res.append(os.waitpid(pid, 0)[1])
+print(*res)
+`
+
+func TestSandbox_workDir(t *testing.T) {
+	res, proc := runSandboxed(t, ActionSandbox{WorkDir: true},
+		"#!/bin/bash\nwhile read a; do echo $PWD $HOME $TMPDIR >&3; done\n")
+	dirs := strings.Fields(res)
+	require.Len(t, dirs, 3)
+	assert.Equal(t, dirs[0], dirs[1])
+	assert.Equal(t, dirs[0], dirs[2])
+	assert.DirExists(t, dirs[0])
+	proc.Stop()
+	assert.NoDirExists(t, dirs[0])
+}
+
+func TestSandbox_credential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	// the action dir is protected as the proxy does
+	dir, _ := os.MkdirTemp("", "action")
+	defer os.RemoveAll(dir)
+	sandbox := ActionSandbox{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}, WorkDir: true}
+	require.NoError(t, sandbox.protectDir(dir))
+	script := filepath.Join(dir, "exec")
+	os.WriteFile(script, []byte("#!/bin/bash\nwhile read a; do echo $(id -u) $(id -g) $(stat -c %U $PWD) >&3; done\n"), 0755)
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	proc := NewExecutor(log, log, script, m)
+	proc.sandbox = sandbox
+	require.NoError(t, proc.Start(false))
+	out, err := proc.Interact([]byte("{}"))
+	require.NoError(t, err)
+	res := strings.TrimSpace(string(out))
+	proc.Stop()
+	assert.Regexp(t, "^65534 65534 ", res)
+	assert.NotRegexp(t, " root$", res)
+}
+
+func TestSandbox_protectDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	dir := t.TempDir()
+	// entries extracted from an archive as world writable
+	require.NoError(t, os.MkdirAll(dir+"/bin/lib", 0777))
+	require.NoError(t, os.WriteFile(dir+"/bin/exec", []byte("#!/bin/sh\n"), 0777))
+	require.NoError(t, os.WriteFile(dir+"/bin/lib/data", []byte("x"), 0666))
+	require.NoError(t, os.Chmod(dir+"/bin/lib", 0777))
+	require.NoError(t, os.Chmod(dir+"/bin/exec", 0777))
+	require.NoError(t, os.Chmod(dir+"/bin/lib/data", 0666))
+	require.NoError(t, os.Symlink("/etc/passwd", dir+"/bin/link"))
+	sandbox := ActionSandbox{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	require.NoError(t, sandbox.protectDir(dir))
+	for path, mode := range map[string]os.FileMode{
+		dir:                   0750,
+		dir + "/bin":          0750,
+		dir + "/bin/lib":      0750,
+		dir + "/bin/exec":     0750,
+		dir + "/bin/lib/data": 0640,
+	} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), path)
+		assert.Equal(t, uint32(65534), info.Sys().(*syscall.Stat_t).Gid, path)
+	}
+	// the target of a link is left alone
+	info, err := os.Stat("/etc/passwd")
+	require.NoError(t, err)
+	assert.NotEqual(t, uint32(65534), info.Sys().(*syscall.Stat_t).Gid)
+}
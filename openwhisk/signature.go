@@ -0,0 +1,117 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// names of the init env keys carrying the signature, when it is not in the init fields
+const (
+	SignatureEnv      = "OW_SIGNATURE"
+	SignatureKeyIDEnv = "OW_SIGNATURE_KEY_ID"
+)
+
+// SignatureError is returned when the signature of an action is missing or invalid
+type SignatureError struct {
+	msg string
+}
+
+func (e *SignatureError) Error() string {
+	return e.msg
+}
+
+// parseSigningKeys parses the public keys of OW_SIGNING_KEYS, a comma separated list of id=key;
+// the id can be omitted if there is only one key. A key is the base64 of a raw ed25519 public key,
+// or of its DER encoding, or a PEM block. No keys means unsigned actions are accepted.
+func parseSigningKeys(value string) (map[string]ed25519.PublicKey, error) {
+	keys := map[string]ed25519.PublicKey{}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return keys, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		id, key, found := strings.Cut(strings.TrimSpace(entry), "=")
+		// base64 can end with =, so the id is there only if the key is not empty
+		if !found || strings.Trim(key, "=") == "" || strings.HasPrefix(id, "-----") {
+			id, key = "", strings.TrimSpace(entry)
+		}
+		pub, err := parsePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing_keys: key %q: %v", id, err)
+		}
+		keys[id] = pub
+	}
+	return keys, nil
+}
+
+// parsePublicKey parses an ed25519 public key, raw or DER encoded in base64, or PEM
+func parsePublicKey(key string) (ed25519.PublicKey, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		der = block.Bytes
+	} else {
+		buf, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(buf) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(buf), nil
+		}
+		der = buf
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if edPub, ok := pub.(ed25519.PublicKey); ok {
+		return edPub, nil
+	}
+	return nil, fmt.Errorf("not an ed25519 key")
+}
+
+// errInvalidSignature tells the scheme of the signatures, as a plain ed25519 signature of the code is a common mistake
+const errInvalidSignature = "invalid signature of the action: it must be the Ed25519ph signature of the SHA-512 of the code"
+
+// verifySignature checks the base64 Ed25519ph signature of the SHA-512 digest of the decoded code,
+// with the key with the given id
+func verifySignature(keys map[string]ed25519.PublicKey, digest []byte, signature string, keyID string) error {
+	if signature == "" {
+		return &SignatureError{"the action is not signed"}
+	}
+	key, ok := keys[keyID]
+	if !ok && keyID == "" && len(keys) == 1 {
+		for _, only := range keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return &SignatureError{fmt.Sprintf("unknown signing key %q", keyID)}
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || ed25519.VerifyWithOptions(key, digest, sig, &ed25519.Options{Hash: crypto.SHA512}) != nil {
+		return &SignatureError{errInvalidSignature}
+	}
+	return nil
+}
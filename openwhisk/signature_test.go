@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a fixed key, so the examples are reproducible
+var testSigningKey = ed25519.NewKeyFromSeed([]byte("0123456789abcdef0123456789abcdef"))
+
+func testPublicKey() string {
+	return base64.StdEncoding.EncodeToString(testSigningKey.Public().(ed25519.PublicKey))
+}
+
+// sign signs the SHA-512 of the code with Ed25519ph
+func sign(code []byte) string {
+	digest := sha512.Sum512(code)
+	sig, _ := testSigningKey.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func initSigned(code []byte, signature string, keyID string) string {
+	body := initBodyRequest{Binary: true, Code: base64.StdEncoding.EncodeToString(code), Signature: signature, KeyID: keyID}
+	j, _ := json.Marshal(initRequest{Value: body})
+	return string(j)
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	pub := testSigningKey.Public().(ed25519.PublicKey)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	derKey := base64.StdEncoding.EncodeToString(der)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	for _, value := range []string{testPublicKey(), derKey, pemKey} {
+		keys, err := parseSigningKeys(value)
+		require.NoError(t, err)
+		require.Equal(t, map[string]ed25519.PublicKey{"": pub}, keys)
+	}
+
+	keys, err := parseSigningKeys("ci=" + testPublicKey() + ", old=" + derKey)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ed25519.PublicKey{"ci": pub, "old": pub}, keys)
+
+	_, err = parseSigningKeys("ci=bad")
+	require.ErrorContains(t, err, "invalid signing_keys")
+
+	keys, err = parseSigningKeys("")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub := testSigningKey.Public().(ed25519.PublicKey)
+	code := []byte("#!/bin/sh\necho hello\n")
+	one := map[string]ed25519.PublicKey{"ci": pub}
+	digest := func(code []byte) []byte {
+		sum := sha512.Sum512(code)
+		return sum[:]
+	}
+	require.NoError(t, verifySignature(one, digest(code), sign(code), "ci"))
+	// the key id can be omitted with a single key
+	require.NoError(t, verifySignature(one, digest(code), sign(code), ""))
+	require.EqualError(t, verifySignature(one, digest(code), "", ""), "the action is not signed")
+	require.EqualError(t, verifySignature(one, digest(code), sign(code), "other"), `unknown signing key "other"`)
+	require.EqualError(t, verifySignature(one, digest(append(code, ' ')), sign(code), "ci"), errInvalidSignature)
+	require.EqualError(t, verifySignature(one, digest(code), "!!", "ci"), errInvalidSignature)
+	// a pure ed25519 signature of the code is not accepted
+	pure := base64.StdEncoding.EncodeToString(ed25519.Sign(testSigningKey, code))
+	require.EqualError(t, verifySignature(one, digest(code), pure, "ci"), errInvalidSignature)
+	two := map[string]ed25519.PublicKey{"ci": pub, "old": pub}
+	require.EqualError(t, verifySignature(two, digest(code), sign(code), ""), `unknown signing key ""`)
+}
+
+func Example_initSigned() {
+	code, _ := os.ReadFile("_test/hello.sh")
+	os.Setenv("OW_SIGNING_KEYS", "ci="+testPublicKey())
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary("_test/hello.sh", ""))
+	doInit(ts, initSigned(append(code, '\n'), sign(code), "ci"))
+	doInit(ts, initSigned(code, sign(code), "ci"))
+	doRun(ts, "")
+	stopTestServer(ts, cur, log)
+	os.Unsetenv("OW_SIGNING_KEYS")
+	// Output:
+	// 403 {"error":"the action is not signed"}
+	// 403 {"error":"invalid signature of the action: it must be the Ed25519ph signature of the SHA-512 of the code"}
+	// 200 {"ok":true}
+	// 200 {"hello": "Mike"}
+	// msg=hello Mike
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_initSignedInEnv() {
+	code, _ := os.ReadFile("_test/hello.sh")
+	os.Setenv("OW_SIGNING_KEYS", testPublicKey())
+	ts, cur, log := startTestServer("")
+	body := initBodyRequest{Code: string(code), Env: map[string]interface{}{SignatureEnv: sign(code)}}
+	j, _ := json.Marshal(initRequest{Value: body})
+	doInit(ts, string(j))
+	stopTestServer(ts, cur, log)
+	os.Unsetenv("OW_SIGNING_KEYS")
+	// Output:
+	// 200 {"ok":true}
+}
@@ -108,7 +108,7 @@ func stopAndDelete(ap *ActionProxy, innerAPValue *RemoteAPValue, actionCodeHash
 }
 
 func cleanUpAP(ap *ActionProxy) {
-	ap.theExecutor.Stop()
+	ap.versions.stop()
 	if err := os.RemoveAll(filepath.Join(ap.baseDir, strconv.Itoa(ap.currentDir))); err != nil {
 		Debug("Error removing action directory: %v", err)
 	}
@@ -159,16 +159,7 @@ func (serverAp *ActionProxy) timedDelete(actionCodeHash string) {
 		return
 	}
 
-	timerDuration := timeToDeletion
-	deleleTimerMs := os.Getenv("OW_DELETE_DURATION")
-	if deleleTimerMs != "" {
-		dur, err := time.ParseDuration(deleleTimerMs)
-		if err != nil {
-			Debug("Error parsing OW_DELETE_DURATION: %v", err)
-		} else {
-			timerDuration = dur
-		}
-	}
+	timerDuration := serverAp.config.DeleteDuration
 
 	Debug("Starting wait cycle for stopping hash '%s'", actionCodeHash)
 	<-time.After(timerDuration)
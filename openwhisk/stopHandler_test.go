@@ -43,7 +43,7 @@ func TestStopHandler(t *testing.T) {
 
 		// setup the server
 		buf, _ := os.CreateTemp("", "log")
-		rootAP := NewActionProxy(dir, "", buf, buf, ProxyModeServer)
+		rootAP := NewActionProxy(testConfig(dir, "", ProxyModeServer), buf, buf)
 		rootAP.serverProxyData = &ServerProxyData{
 			actions: make(map[RemoteAPKey]*RemoteAPValue),
 		}
@@ -87,7 +87,7 @@ func TestStopHandler(t *testing.T) {
 
 		// setup the server
 		buf, _ := os.CreateTemp("", "log")
-		rootAP := NewActionProxy(dir, "", buf, buf, ProxyModeServer)
+		rootAP := NewActionProxy(testConfig(dir, "", ProxyModeServer), buf, buf)
 		rootAP.serverProxyData = &ServerProxyData{
 			actions: make(map[RemoteAPKey]*RemoteAPValue),
 		}
@@ -142,7 +142,7 @@ func TestStopHandler(t *testing.T) {
 
 		// setup the server
 		buf, _ := os.CreateTemp("", "log")
-		rootAP := NewActionProxy(dir, "", buf, buf, ProxyModeServer)
+		rootAP := NewActionProxy(testConfig(dir, "", ProxyModeServer), buf, buf)
 		rootAP.serverProxyData = &ServerProxyData{
 			actions: make(map[RemoteAPKey]*RemoteAPValue),
 		}
@@ -197,7 +197,7 @@ func TestStopHandler(t *testing.T) {
 
 		// setup the server
 		buf, _ := os.CreateTemp("", "log")
-		rootAP := NewActionProxy(dir, "", buf, buf, ProxyModeServer)
+		rootAP := NewActionProxy(testConfig(dir, "", ProxyModeServer), buf, buf)
 		rootAP.serverProxyData = &ServerProxyData{
 			actions: make(map[RemoteAPKey]*RemoteAPValue),
 		}
@@ -252,7 +252,7 @@ func TestStopHandler(t *testing.T) {
 
 		// setup the server
 		buf, _ := os.CreateTemp("", "log")
-		rootAP := NewActionProxy(dir, "", buf, buf, ProxyModeServer)
+		rootAP := NewActionProxy(testConfig(dir, "", ProxyModeServer), buf, buf)
 		rootAP.serverProxyData = &ServerProxyData{
 			actions: make(map[RemoteAPKey]*RemoteAPValue),
 		}
@@ -276,8 +276,7 @@ func TestStopHandler(t *testing.T) {
 		err := os.WriteFile(setupCheckFile, []byte("setup"), 0644)
 		require.NoError(t, err)
 
-		oldtimerToDeletion := timeToDeletion
-		timeToDeletion = 100 * time.Millisecond
+		rootAP.config.DeleteDuration = 100 * time.Millisecond
 		doStop(ts, actionCodeHash, actionID)
 
 		require.Contains(t, rootAP.serverProxyData.actions, actionCodeHash)
@@ -292,7 +291,6 @@ func TestStopHandler(t *testing.T) {
 
 		stopTestServer(ts, oldCurrentDir, buf)
 
-		timeToDeletion = oldtimerToDeletion
 		setupActionPath = oldSetupActionPath
 	})
 }
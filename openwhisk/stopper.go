@@ -19,6 +19,7 @@ package openwhisk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,6 +28,7 @@ import (
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 func (ap *ActionProxy) HookExitSignals() {
@@ -45,16 +47,34 @@ func listenOnExitSignals(ap *ActionProxy, captureSignalChan chan os.Signal) {
 		syscall.SIGHUP)
 	defer signal.Stop(captureSignalChan)
 
-	Debug("Listening on exit signals for remote action cleanup...")
+	Debug("Listening on exit signals for remote action cleanup and to export the spans...")
 	signalHandler(<-captureSignalChan, ap)
 }
 
 func signalHandler(signal os.Signal, ap *ActionProxy) {
 	Debug("Caught signal: %v", signal)
 
-	_ = SendStopRequest(ap)
+	if ap.clientProxyData != nil {
+		_ = SendStopRequest(ap)
+		Debug("Finished remote action cleanup.")
+	}
+
+	// stop the action, and the ones served for the clients, so their process groups do not outlive the proxy
+	if ap.versions != nil {
+		ap.versions.stop()
+	}
+	if ap.serverProxyData != nil {
+		for _, nestedAP := range ap.serverProxyData.actions {
+			nestedAP.remoteProxy.versions.stop()
+		}
+	}
+
+	// export the spans still queued, waiting a few seconds at most
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ap.tracer.shutdown(ctx)
 
-	Debug("Finished remote action cleanup. Exiting.")
+	Debug("Exiting.")
 }
 
 func SendStopRequest(ap *ActionProxy) error {
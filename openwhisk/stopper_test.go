@@ -23,6 +23,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -78,3 +79,13 @@ func TestListenOnExitSignals(t *testing.T) {
 	signalChan <- os.Interrupt
 	listenOnExitSignals(ap, signalChan)
 }
+
+func TestSignalHandler_stopsAction(t *testing.T) {
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	require.NoError(t, startVersionEmitting(ap, "1"))
+	executor := ap.versions.executor()
+
+	signalHandler(syscall.SIGTERM, ap)
+	require.True(t, executor.Exited())
+	require.Nil(t, ap.versions.executor())
+}
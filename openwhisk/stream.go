@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StreamChunkKey is the key of the messages an action writes in fd3 to stream a chunk of its response.
+// An action can write any number of {"__ow_chunk": <json value>} lines before its final result.
+const StreamChunkKey = "__ow_chunk"
+
+// content types of the streamed responses
+const (
+	// EventStreamContentType is used when the caller accepts Server-Sent Events
+	EventStreamContentType = "text/event-stream"
+	// NdjsonContentType is used otherwise, one JSON value per line, the last one being the result
+	NdjsonContentType = "application/x-ndjson"
+	// hopStreamContentType is used between a forwarding proxy and a server proxy
+	hopStreamContentType = "application/vnd.openwhisk.stream+x-ndjson"
+)
+
+// parseChunk checks if a line from the action is a stream chunk and returns its value
+func parseChunk(line []byte) ([]byte, bool) {
+	if !bytes.Contains(line, []byte(`"`+StreamChunkKey+`"`)) {
+		return nil, false
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(line, &msg); err != nil || len(msg) != 1 {
+		return nil, false
+	}
+	chunk, ok := msg[StreamChunkKey]
+	return chunk, ok
+}
+
+// streamWriter relays the chunks of a streaming action to the caller,
+// as Server-Sent Events or as newline delimited JSON
+type streamWriter struct {
+	w     io.Writer
+	flush func()
+	sse   bool
+}
+
+// newStreamWriter creates a stream writer in the format accepted by the caller
+func newStreamWriter(w io.Writer, accept string) *streamWriter {
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	return &streamWriter{w, flush, strings.Contains(accept, EventStreamContentType)}
+}
+
+// contentType is the content type of the stream
+func (s *streamWriter) contentType() string {
+	if s.sse {
+		return EventStreamContentType
+	}
+	return NdjsonContentType
+}
+
+// start sends the headers of a streamed response
+func (s *streamWriter) start(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", s.contentType())
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	s.flush()
+}
+
+func (s *streamWriter) write(event string, data []byte) error {
+	var err error
+	switch {
+	case s.sse && event != "":
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	case s.sse:
+		_, err = fmt.Fprintf(s.w, "data: %s\n\n", data)
+	default:
+		_, err = fmt.Fprintf(s.w, "%s\n", data)
+	}
+	s.flush()
+	return err
+}
+
+// chunk sends a chunk of the response
+func (s *streamWriter) chunk(data []byte) error {
+	return s.write("", bytes.TrimSpace(data))
+}
+
+// end sends the final result of the action
+func (s *streamWriter) end(result []byte) error {
+	return s.write("result", bytes.TrimSpace(result))
+}
+
+// fail reports an error happened after the stream started, when the status cannot change anymore
+func (s *streamWriter) fail(cause string) error {
+	buf, _ := json.Marshal(ErrResponse{Error: cause})
+	return s.write("error", buf)
+}
+
+// hopChunk is a chunk sent by a server proxy to a forwarding proxy;
+// the last line of the hop stream is a RemoteRunResponse or an ErrResponse
+type hopChunk struct {
+	Chunk json.RawMessage `json:"chunk"`
+}
+
+// relayHopStream reads a hop stream from a server proxy and writes it to the caller,
+// passing the logs of the action to the given callback
+func relayHopStream(in io.Reader, out *streamWriter, logs func(*RemoteRunResponse)) error {
+	dec := json.NewDecoder(in)
+	for {
+		var msg map[string]json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			out.fail("stream from remote runtime interrupted")
+			return err
+		}
+		if chunk, ok := msg["chunk"]; ok {
+			if err := out.chunk(chunk); err != nil {
+				return err
+			}
+			continue
+		}
+		if cause, ok := msg["error"]; ok {
+			var errMsg string
+			json.Unmarshal(cause, &errMsg)
+			return out.fail(errMsg)
+		}
+		var final RemoteRunResponse
+		json.Unmarshal(msg["response"], &final.Response)
+		json.Unmarshal(msg["out"], &final.Out)
+		json.Unmarshal(msg["err"], &final.Err)
+		logs(&final)
+		return out.end(final.Response)
+	}
+}
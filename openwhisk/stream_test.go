@@ -0,0 +1,129 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func doStreamRun(ts *httptest.Server, accept string, message string) {
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/run", bytes.NewBufferString(`{"value":`+message+`}`))
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	fmt.Printf("%d %s\n%s", res.StatusCode, res.Header.Get("Content-Type"), body)
+}
+
+func TestParseChunk(t *testing.T) {
+	chunk, ok := parseChunk([]byte(`{"__ow_chunk": {"a":1}}`))
+	require.True(t, ok)
+	require.Equal(t, `{"a":1}`, string(chunk))
+	_, ok = parseChunk([]byte(`{"__ow_chunk": 1, "other": 2}`))
+	require.False(t, ok)
+	_, ok = parseChunk([]byte(`{"result": "__ow_chunk"}`))
+	require.False(t, ok)
+	_, ok = parseChunk([]byte(`{"hello": "world"}`))
+	require.False(t, ok)
+}
+
+func Example_streamNdjson() {
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary("_test/stream.sh", ""))
+	doStreamRun(ts, "", `{"name":"Mike"}`)
+	doStreamRun(ts, "", `{"name":"bad"}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 application/x-ndjson
+	// {"count": 1}
+	// "hello"
+	// {"hello": "Mike"}
+	// 200 application/x-ndjson
+	// "partial"
+	// {"error":"command exited"}
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_streamSSE() {
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary("_test/stream.sh", ""))
+	doStreamRun(ts, "text/event-stream", `{"name":"Mike"}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 text/event-stream
+	// data: {"count": 1}
+	//
+	// data: "hello"
+	//
+	// event: result
+	// data: {"hello": "Mike"}
+	//
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func Example_streamForwarded() {
+	clientLog, _ := os.CreateTemp("", "log")
+	clientAP := NewActionProxy(testConfig("", "", ProxyModeClient), clientLog, clientLog)
+	serverLog, _ := os.CreateTemp("", "log")
+	serverAP := NewActionProxy(testConfig("./action", "", ProxyModeServer), serverLog, serverLog)
+	server := httptest.NewServer(serverAP)
+	client := httptest.NewServer(clientAP)
+
+	doInit(client, initBinary("_test/stream.sh", "@"+server.URL))
+	doStreamRun(client, "", `{"name":"Mike"}`)
+	doStreamRun(client, "text/event-stream", `{"name":"Mike"}`)
+
+	client.Close()
+	server.Close()
+	os.Remove(serverLog.Name())
+	os.Remove(clientLog.Name())
+	// Output:
+	// 200 {"ok":true}
+	// 200 application/x-ndjson
+	// {"count":1}
+	// "hello"
+	// {"hello":"Mike"}
+	// 200 text/event-stream
+	// data: {"count":1}
+	//
+	// data: "hello"
+	//
+	// event: result
+	// data: {"hello":"Mike"}
+}
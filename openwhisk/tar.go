@@ -25,11 +25,8 @@ import (
 	"path/filepath"
 )
 
-func openTar(src []byte) (*tar.Reader, error) {
-	// Create a new bytes.Reader from the input byte slice
-	reader := bytes.NewReader(src)
-
-	// Create a new gzip.Reader from the bytes.Reader
+func openTar(reader io.Reader) (*tar.Reader, error) {
+	// Create a new gzip.Reader from the reader
 	gzipReader, err := gzip.NewReader(reader)
 	if err != nil {
 		return nil, err
@@ -42,7 +39,18 @@ func openTar(src []byte) (*tar.Reader, error) {
 	return tarReader, nil
 }
 
+// UnTar extracts a tar.gz file in the given destination folder
 func UnTar(src []byte, dest string) error {
+	return UnTarFrom(bytes.NewReader(src), dest)
+}
+
+// UnTarFrom is like UnTar but streams the tar.gz file from a reader
+func UnTarFrom(src io.Reader, dest string) error {
+	return untar(src, dest, nil)
+}
+
+// untar extracts a tar.gz file within the limits of the budget
+func untar(src io.Reader, dest string, budget *extractBudget) error {
 	r, err := openTar(src)
 	if err != nil {
 		return err
@@ -66,6 +74,10 @@ func UnTar(src []byte, dest string) error {
 			continue
 		}
 
+		if err := budget.entry(header.Name, header.Size); err != nil {
+			return err
+		}
+
 		// the target location where the dir/file should be created
 		target := filepath.Join(dest, header.Name)
 		// isLink := header.FileInfo().Mode()&os.ModeSymlink == os.ModeSymlink
@@ -93,7 +105,8 @@ func UnTar(src []byte, dest string) error {
 			}
 
 			// copy over contents
-			if _, err := io.Copy(f, r); err != nil {
+			if err := budget.copy(f, r, header.Name); err != nil {
+				f.Close()
 				return err
 			}
 
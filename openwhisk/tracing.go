@@ -0,0 +1,227 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader is the W3C header propagating the trace context
+const TraceparentHeader = "Traceparent"
+
+// DefaultServiceName is the name of the proxy in the traces, unless OTEL_SERVICE_NAME is set
+const DefaultServiceName = "openwhisk-actionloop"
+
+// TraceContext identifies a span in a trace, as the W3C traceparent
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// ParseTraceparent parses a W3C traceparent header, as 00-<trace id>-<span id>-<flags>
+func ParseTraceparent(header string) (TraceContext, bool) {
+	var tc TraceContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return tc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return tc, false
+	}
+	if n, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil || n != len(tc.TraceID) || len(parts[1]) != 32 {
+		return tc, false
+	}
+	if n, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil || n != len(tc.SpanID) || len(parts[2]) != 16 {
+		return tc, false
+	}
+	tc.Flags = flags[0]
+	return tc, tc.IsValid()
+}
+
+// IsValid checks that the ids are not zero
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// String formats the context as a W3C traceparent
+func (tc TraceContext) String() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.SpanID[:]) + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// the kinds of the spans of the proxy
+const (
+	spanKindInternal = trace.SpanKindInternal
+	spanKindServer   = trace.SpanKindServer
+	spanKindClient   = trace.SpanKindClient
+)
+
+// span is an operation of the proxy; all its methods can be called on a nil span, when tracing is disabled
+type span struct {
+	span trace.Span
+}
+
+// setAttribute sets an attribute of the span, a string, a bool or a number
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// setError marks the span as failed, if there is an error
+func (s *span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// traceparent returns the W3C traceparent of the span, to continue its trace;
+// it is unsampled when the span is not recorded, so the trace is not recorded downstream either
+func (s *span) traceparent() string {
+	if s == nil {
+		return ""
+	}
+	sc := s.span.SpanContext()
+	return TraceContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), Flags: byte(sc.TraceFlags())}.String()
+}
+
+// end ends the span, queuing it for the export when it is sampled
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// tracer exports the spans of the proxy with the OpenTelemetry SDK, in batches with OTLP over HTTP
+type tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// loadTracer configures the tracer with the standard OpenTelemetry variables:
+// tracing is enabled by OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT,
+// while the exporter, the batches and the sampler read the other ones
+func loadTracer() *tracer {
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil
+	}
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		Debug("cannot export the spans: %v", err)
+		return nil
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(DefaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		Debug("cannot describe the service of the spans: %v", err)
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		Debug("cannot export the spans: %v", err)
+	}))
+	// the default sampler is parent based, so the spans continuing a trace are recorded only when it is sampled
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	return &tracer{
+		provider: provider,
+		tracer:   provider.Tracer("github.com/apache/openserverless-runtimes/openwhisk", trace.WithInstrumentationVersion(Version)),
+	}
+}
+
+// startSpan starts a span, child of the span in the context or else of the traceparent in the header, if any;
+// the returned context carries the new span
+func (t *tracer) startSpan(ctx context.Context, name string, kind trace.SpanKind, header http.Header) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		if remote, ok := ParseTraceparent(header.Get(TraceparentHeader)); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    remote.TraceID,
+				SpanID:     remote.SpanID,
+				TraceFlags: trace.TraceFlags(remote.Flags),
+				Remote:     true,
+			}))
+		}
+	}
+	ctx, s := t.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &span{s}
+}
+
+// startChildSpan starts an internal span, only as a child of the span in the context:
+// the steps of the proxy outside of a request, as reloading an action, are not traced
+func (t *tracer) startChildSpan(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return t.startSpan(ctx, name, spanKindInternal, nil)
+}
+
+// shutdown exports the spans still queued and stops the tracer, when the proxy exits
+func (t *tracer) shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	if err := t.provider.Shutdown(ctx); err != nil {
+		Debug("cannot export the spans: %v", err)
+	}
+}
+
+// withTraceparent returns the header with the traceparent of the span, to propagate the trace
+func withTraceparent(header http.Header, s *span) http.Header {
+	if s == nil {
+		return header
+	}
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(TraceparentHeader, s.traceparent())
+	return header
+}
@@ -0,0 +1,328 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand-in of an OpenTelemetry collector, receiving the spans with OTLP/HTTP
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	spans    []exportedSpan
+	headers  http.Header
+	services []string
+}
+
+// exportedSpan is a span received by the collector, with the ids in hex
+type exportedSpan struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         trace.SpanKind
+	Error        string
+}
+
+func startCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var traces coltracepb.ExportTraceServiceRequest
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &traces) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header
+		for _, rs := range traces.ResourceSpans {
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					c.services = append(c.services, attr.Value.GetStringValue())
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					c.spans = append(c.spans, exportedSpan{
+						TraceID:      hex.EncodeToString(s.TraceId),
+						SpanID:       hex.EncodeToString(s.SpanId),
+						ParentSpanID: hex.EncodeToString(s.ParentSpanId),
+						Name:         s.Name,
+						Kind:         trace.SpanKind(s.Kind),
+						Error:        s.GetStatus().GetMessage(),
+					})
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	t.Cleanup(c.Close)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-token=a%20b")
+	return c
+}
+
+// wait flushes the tracers until the collector has the named spans, as they end after the responses;
+// the spans are returned by name, the last one when more have the same name
+func (c *collector) wait(t *testing.T, tracers []*tracer, names ...string) map[string]exportedSpan {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, tracer := range tracers {
+			tracer.provider.ForceFlush(context.Background())
+		}
+		spans := map[string]exportedSpan{}
+		for _, s := range c.all() {
+			spans[s.Name] = s
+		}
+		missing := false
+		for _, name := range names {
+			_, ok := spans[name]
+			missing = missing || !ok
+		}
+		if !missing {
+			return spans
+		}
+		if time.Now().After(deadline) {
+			require.Failf(t, "missing spans", "want %v, got %v", names, spans)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// all returns the spans received so far
+func (c *collector) all() []exportedSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]exportedSpan{}, c.spans...)
+}
+
+// child waits for the span with the given name and parent, flushing the tracers
+func (c *collector) child(t *testing.T, tracers []*tracer, name string, parent exportedSpan) exportedSpan {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, s := range c.all() {
+			if s.Name == name && s.ParentSpanID == parent.SpanID {
+				return s
+			}
+		}
+		if time.Now().After(deadline) {
+			require.Failf(t, "missing span", "want %s child of %s", name, parent.SpanID)
+		}
+		for _, tracer := range tracers {
+			tracer.provider.ForceFlush(context.Background())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// doTracedPost posts a request with a traceparent
+func doTracedPost(t *testing.T, url string, body string, traceparent string) (string, int) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TraceparentHeader, traceparent)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	buf, _ := io.ReadAll(res.Body)
+	return strings.TrimSpace(string(buf)), res.StatusCode
+}
+
+// traceparentOf returns the traceparent identifying a span
+func traceparentOf(s exportedSpan) string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", tc.String())
+	assert.Equal(t, byte(1), tc.Flags)
+	// future versions can add fields
+	_, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.True(t, ok)
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	} {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestLoadTracer(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	assert.Nil(t, loadTracer())
+
+	// either endpoint enables it
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	assert.NotNil(t, loadTracer())
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://traces:4318/spans")
+	assert.NotNil(t, loadTracer())
+
+	// a disabled tracer does nothing
+	var disabled *tracer
+	_, span := disabled.startSpan(context.Background(), "run", spanKindServer, nil)
+	assert.Nil(t, span)
+	span.setAttribute("key", "value")
+	span.end()
+	disabled.shutdown(context.Background())
+}
+
+func TestTracing_run(t *testing.T) {
+	c := startCollector(t)
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	config := testConfig(t.TempDir(), "", ProxyModeNone)
+	config.ActivationContext = true
+	ap := NewActionProxy(config, log, log)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.versions.stop()
+
+	invoker := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_, status := doTracedPost(t, ts.URL+"/init", initBinary("_test/context.sh", ""), invoker)
+	assert.Equal(t, http.StatusOK, status)
+	res, status := doTracedPost(t, ts.URL+"/run", `{"value":{},"activation_id":"a1"}`, invoker)
+	assert.Equal(t, http.StatusOK, status)
+
+	spans := c.wait(t, []*tracer{ap.tracer}, "init", "extract", "start", "run", "interact")
+	assert.Equal(t, "a b", c.headers.Get("x-token"))
+	for _, name := range []string{"init", "run"} {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[name].TraceID, name)
+		assert.Equal(t, "00f067aa0ba902b7", spans[name].ParentSpanID, name)
+		assert.Equal(t, spanKindServer, spans[name].Kind, name)
+	}
+	assert.Equal(t, spans["init"].SpanID, spans["extract"].ParentSpanID)
+	assert.Equal(t, spans["init"].SpanID, spans["start"].ParentSpanID)
+	assert.Equal(t, spans["run"].SpanID, spans["interact"].ParentSpanID)
+	assert.Empty(t, spans["interact"].Error)
+	assert.Contains(t, c.services, DefaultServiceName)
+
+	// the action continues the trace from the interact span
+	var ctx ActivationContext
+	require.NoError(t, json.Unmarshal([]byte(res), &ctx))
+	assert.Equal(t, "a1", ctx.ActivationID)
+	assert.Equal(t, traceparentOf(spans["interact"]), ctx.Traceparent)
+	assert.NotContains(t, ctx.Headers, "traceparent")
+}
+
+func TestTracing_sampled(t *testing.T) {
+	c := startCollector(t)
+	// the spans are exported only at the shutdown
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "600000")
+	t.Setenv("OTEL_SERVICE_NAME", "hello")
+	log, _ := os.CreateTemp("", "log")
+	defer os.Remove(log.Name())
+	config := testConfig(t.TempDir(), "", ProxyModeNone)
+	config.ActivationContext = true
+	ap := NewActionProxy(config, log, log)
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	defer ap.versions.stop()
+
+	// an unsampled trace is continued, but not recorded
+	unsampled := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	_, status := doTracedPost(t, ts.URL+"/init", initBinary("_test/context.sh", ""), unsampled)
+	assert.Equal(t, http.StatusOK, status)
+	res, status := doTracedPost(t, ts.URL+"/run", `{"value":{}}`, unsampled)
+	assert.Equal(t, http.StatusOK, status)
+	var ctx ActivationContext
+	require.NoError(t, json.Unmarshal([]byte(res), &ctx))
+	tc, ok := ParseTraceparent(ctx.Traceparent)
+	require.True(t, ok, ctx.Traceparent)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", hex.EncodeToString(tc.TraceID[:]))
+	assert.Equal(t, byte(0), tc.Flags)
+
+	sampled := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_, status = doTracedPost(t, ts.URL+"/run", `{"value":{}}`, sampled)
+	assert.Equal(t, http.StatusOK, status)
+
+	// the shutdown exports the spans still queued
+	ap.tracer.shutdown(context.Background())
+	names := []string{}
+	for _, s := range c.all() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID, s.Name)
+		names = append(names, s.Name)
+	}
+	assert.ElementsMatch(t, []string{"run", "interact"}, names)
+	assert.Equal(t, "a b", c.headers.Get("x-token"))
+	assert.Contains(t, c.services, "hello")
+}
+
+func TestTracing_forward(t *testing.T) {
+	c := startCollector(t)
+	clientLog, _ := os.CreateTemp("", "log")
+	defer os.Remove(clientLog.Name())
+	clientConfig := testConfig("", "", ProxyModeClient)
+	clientConfig.ActivationContext = true
+	clientAP := NewActionProxy(clientConfig, clientLog, clientLog)
+	serverLog, _ := os.CreateTemp("", "log")
+	defer os.Remove(serverLog.Name())
+	serverAP := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeServer), serverLog, serverLog)
+	server := httptest.NewServer(serverAP)
+	defer server.Close()
+	client := httptest.NewServer(clientAP)
+	defer client.Close()
+	tracers := []*tracer{clientAP.tracer, serverAP.tracer}
+
+	invoker := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_, status := doTracedPost(t, client.URL+"/init", initBinary("_test/context.sh", "@"+server.URL), invoker)
+	assert.Equal(t, http.StatusOK, status)
+	spans := c.wait(t, tracers, "forward init", "extract", "start")
+	assert.Equal(t, spanKindClient, spans["forward init"].Kind)
+	init := c.child(t, tracers, "init", spans["forward init"])
+	assert.Equal(t, init.SpanID, spans["start"].ParentSpanID)
+
+	// the server continues the trace of the hop
+	res, status := doTracedPost(t, client.URL+"/run", `{"value":{}}`, invoker)
+	assert.Equal(t, http.StatusOK, status)
+	spans = c.wait(t, tracers, "forward run", "interact")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans["interact"].TraceID)
+	run := c.child(t, tracers, "run", spans["forward run"])
+	assert.Equal(t, run.SpanID, spans["interact"].ParentSpanID)
+	var ctx ActivationContext
+	require.NoError(t, json.Unmarshal([]byte(res), &ctx))
+	assert.Equal(t, traceparentOf(spans["interact"]), ctx.Traceparent)
+}
@@ -36,6 +36,16 @@ import (
 	"time"
 )
 
+// testConfig returns the configuration in the environment, with the given action directory, compiler and mode
+func testConfig(dir string, compiler string, mode ProxyMode) *Config {
+	config, err := LoadConfig("", nil)
+	if err != nil {
+		panic(err)
+	}
+	config.ActionDir, config.Compiler, config.ProxyMode = dir, compiler, mode
+	return config
+}
+
 func startTestServer(compiler string) (*httptest.Server, string, *os.File) {
 	// temporary workdir
 	cur, _ := os.Getwd()
@@ -46,7 +56,7 @@ func startTestServer(compiler string) (*httptest.Server, string, *os.File) {
 	log.Print(dir)
 	// setup the server
 	buf, _ := os.CreateTemp("", "log")
-	ap := NewActionProxy(dir, compiler, buf, buf, ProxyModeNone)
+	ap := NewActionProxy(testConfig(dir, compiler, ProxyModeNone), buf, buf)
 	ts := httptest.NewServer(ap)
 	log.Printf(ts.URL)
 	doPost(ts.URL+"/init", `{value: {code: ""}}`)
@@ -194,6 +204,8 @@ func removeLineNr(out string) string {
 	return re.ReplaceAllString(out, "::")
 }
 func TestMain(m *testing.M) {
+	// the test binary is the sandbox launcher and runs the WASM actions, as the proxy does
+	RunChild()
 	Debugging = false // enable debug of tests
 	if !Debugging {
 		// silence those annoying tests
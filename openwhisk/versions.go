@@ -0,0 +1,178 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"os"
+	"sync"
+)
+
+// DefaultKeepVersions is the number of versions of the action kept on disk, the current one included,
+// so that the proxy can go back to the previous one when a new version does not start
+const DefaultKeepVersions = 2
+
+// ActionVersion is a started version of the action, extracted in a numbered folder of the base dir
+type ActionVersion struct {
+	// Number is the number of the folder of the version
+	Number int
+	// Dir is the folder of the version
+	Dir string
+
+	// executor runs the version
+	executor *Executor
+	// runs counts the runs in flight on the executor
+	runs sync.WaitGroup
+	// retired is set when the executor is stopped
+	retired bool
+}
+
+// release ends a run on the version
+func (v *ActionVersion) release() {
+	v.runs.Done()
+}
+
+// versionRegistry keeps the versions of the action that were started, from the least recent,
+// and the current one, serving the runs
+type versionRegistry struct {
+	mu       sync.Mutex
+	versions []*ActionVersion
+	current  *ActionVersion
+	// keep is how many versions are kept on disk, 0 for all of them
+	keep int
+}
+
+// acquire returns the current version, if any, counting a run on it: the run must release it
+func (r *versionRegistry) acquire() *ActionVersion {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return nil
+	}
+	r.current.runs.Add(1)
+	return r.current
+}
+
+// executor returns the executor of the current version, if any
+func (r *versionRegistry) executor() *Executor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return nil
+	}
+	return r.current.executor
+}
+
+// running checks if the current version is running
+func (r *versionRegistry) running() bool {
+	executor := r.executor()
+	return executor != nil && !executor.Exited()
+}
+
+// swap makes current a started version, atomically for the runs: the runs in flight
+// on the previous version are drained before stopping it
+func (r *versionRegistry) swap(v *ActionVersion) {
+	r.mu.Lock()
+	old := r.current
+	r.current = v
+	// a version started again after a rollback becomes the most recent
+	versions := []*ActionVersion{}
+	for _, version := range r.versions {
+		if version.Number != v.Number {
+			versions = append(versions, version)
+		}
+	}
+	r.versions = append(versions, v)
+	r.mu.Unlock()
+	if old == nil {
+		r.prune()
+		return
+	}
+	Debug("draining the version %d", old.Number)
+	go r.retire(old)
+}
+
+// retire stops a version after its runs, then removes the versions in excess
+func (r *versionRegistry) retire(v *ActionVersion) {
+	v.runs.Wait()
+	Debug("stopping the version %d", v.Number)
+	v.executor.Stop()
+	r.mu.Lock()
+	v.retired = true
+	r.mu.Unlock()
+	r.prune()
+}
+
+// fail removes the version from the runs, if it is still the current one, after its executor failed:
+// it is stopped when the other runs in flight on it are drained
+func (r *versionRegistry) fail(v *ActionVersion) {
+	r.mu.Lock()
+	if r.current != v {
+		r.mu.Unlock()
+		return
+	}
+	r.current = nil
+	r.mu.Unlock()
+	Debug("draining the failed version %d", v.Number)
+	go r.retire(v)
+}
+
+// stop stops the current version, without draining it
+func (r *versionRegistry) stop() {
+	r.mu.Lock()
+	v := r.current
+	r.current = nil
+	if v != nil {
+		v.retired = true
+	}
+	r.mu.Unlock()
+	if v != nil {
+		v.executor.Stop()
+	}
+}
+
+// previous returns the numbers of the versions before the current one, from the most recent
+func (r *versionRegistry) previous() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	numbers := []int{}
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		if r.versions[i] != r.current {
+			numbers = append(numbers, r.versions[i].Number)
+		}
+	}
+	return numbers
+}
+
+// prune removes the folders of the least recent versions, keeping the ones configured with OW_KEEP_VERSIONS;
+// the versions still draining are removed later
+func (r *versionRegistry) prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keep == 0 {
+		return
+	}
+	for len(r.versions) > r.keep {
+		oldest := r.versions[0]
+		if oldest == r.current || !oldest.retired {
+			return
+		}
+		Debug("removing the version %d in %s", oldest.Number, oldest.Dir)
+		os.RemoveAll(oldest.Dir)
+		r.versions = r.versions[1:]
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startVersionEmitting extracts and starts a version of the action emitting a value
+func startVersionEmitting(ap *ActionProxy, value string) error {
+	buf := []byte("#!/bin/sh\nwhile read a; do echo " + value + " >&3 ; done\n")
+	ap.ExtractAction(&buf, "bin")
+	return ap.StartLatestAction()
+}
+
+// startVersionFailing extracts and starts a version of the action exiting at once
+func startVersionFailing(ap *ActionProxy) error {
+	buf := []byte("#!/bin/sh\ntrue\n")
+	ap.ExtractAction(&buf, "bin")
+	return ap.StartLatestAction()
+}
+
+// emitted runs the current version as the run handler does, returning what it emits
+func emitted(ap *ActionProxy) string {
+	version := ap.versions.acquire()
+	if version == nil {
+		return "none"
+	}
+	defer version.release()
+	res, _ := version.executor.Interact([]byte("x"))
+	return string(res)
+}
+
+func TestVersions_swap(t *testing.T) {
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	defer ap.versions.stop()
+	assert.NoError(t, startVersionEmitting(ap, "1"))
+	first := ap.versions.executor()
+	assert.Equal(t, "1\n", emitted(ap))
+
+	// a run in flight on the first version
+	run := ap.versions.acquire()
+	assert.Equal(t, 1, run.Number)
+
+	// the new version serves the next runs at once
+	assert.NoError(t, startVersionEmitting(ap, "2"))
+	assert.Equal(t, "2\n", emitted(ap))
+
+	// the old one is drained before stopping it
+	time.Sleep(500 * time.Millisecond)
+	assert.False(t, first.Exited())
+	res, err := run.executor.Interact([]byte("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(res))
+	run.release()
+	assert.Eventually(t, first.Exited, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestVersions_rollback(t *testing.T) {
+	dir := t.TempDir()
+	ap := NewActionProxy(testConfig(dir, "", ProxyModeNone), os.Stdout, os.Stderr)
+	defer ap.versions.stop()
+	assert.NoError(t, startVersionEmitting(ap, "1"))
+
+	// a version failing to start leaves the current one running
+	assert.Error(t, startVersionFailing(ap))
+	assert.NoDirExists(t, dir+"/2")
+	assert.Equal(t, "1\n", emitted(ap))
+
+	// if the current one is not running any more, the previous one is started again
+	assert.NoError(t, startVersionEmitting(ap, "3"))
+	failed := ap.versions.acquire()
+	ap.versions.fail(failed)
+	failed.release()
+	assert.Equal(t, "none", emitted(ap))
+	assert.Eventually(t, failed.executor.Exited, 5*time.Second, 100*time.Millisecond)
+	assert.Error(t, startVersionFailing(ap))
+	assert.NoDirExists(t, dir+"/4")
+	assert.Equal(t, "3\n", emitted(ap))
+	assert.Equal(t, 3, ap.versions.current.Number)
+
+	// when the versions are all gone, there is nothing to run
+	ap.versions.stop()
+	os.RemoveAll(dir + "/3")
+	os.RemoveAll(dir + "/1")
+	assert.Error(t, startVersionFailing(ap))
+	assert.Equal(t, "none", emitted(ap))
+}
+
+func TestVersions_fail(t *testing.T) {
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	defer ap.versions.stop()
+	assert.NoError(t, startVersionEmitting(ap, "1"))
+
+	// a run fails while another one is in flight
+	failed := ap.versions.acquire()
+	run := ap.versions.acquire()
+	ap.versions.fail(failed)
+	failed.release()
+	assert.Equal(t, "none", emitted(ap))
+
+	// the run in flight completes before the version is stopped
+	time.Sleep(500 * time.Millisecond)
+	assert.False(t, run.executor.Exited())
+	res, err := run.executor.Interact([]byte("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(res))
+	run.release()
+	assert.Eventually(t, run.executor.Exited, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestVersions_retention(t *testing.T) {
+	dir := t.TempDir()
+	ap := NewActionProxy(testConfig(dir, "", ProxyModeNone), os.Stdout, os.Stderr)
+	defer ap.versions.stop()
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, startVersionEmitting(ap, fmt.Sprint(i)))
+	}
+	// the current version and the previous one are kept
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(dir + "/1")
+		return os.IsNotExist(err)
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.DirExists(t, dir+"/2")
+	assert.DirExists(t, dir+"/3")
+
+	// or all of them
+	ap.versions.mu.Lock()
+	ap.versions.keep = 0
+	ap.versions.mu.Unlock()
+	for i := 4; i <= 5; i++ {
+		assert.NoError(t, startVersionEmitting(ap, fmt.Sprint(i)))
+	}
+	time.Sleep(500 * time.Millisecond)
+	for i := 2; i <= 5; i++ {
+		assert.DirExists(t, fmt.Sprintf("%s/%d", dir, i))
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	wazerosys "github.com/tetratelabs/wazero/sys"
+)
+
+// WasmFlag is the flag of the proxy running a WASM action, as <proxy> -wasm <module>:
+// the action runs in its own process as the other actions, so it is stopped and limited in the same way
+const WasmFlag = "-wasm"
+
+// errors of WASI
+const (
+	wasiErrnoSuccess = 0
+	wasiErrnoBadf    = 8
+	wasiErrnoFault   = 21
+	wasiErrnoIO      = 29
+)
+
+// wasmCommand returns the command line running the action, when it is a WASM module
+func wasmCommand(executable string) (string, []string, bool) {
+	f, err := os.Open(executable)
+	if err != nil {
+		return "", nil, false
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	if n, _ := io.ReadFull(f, head); !IsWasm(head[:n]) {
+		return "", nil, false
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, false
+	}
+	// the sandbox can change the working directory
+	module, err := filepath.Abs(executable)
+	if err != nil {
+		return "", nil, false
+	}
+	return self, []string{WasmFlag, module}, true
+}
+
+// RunWasm runs a WASM module with the ActionLoop protocol, in the process started by the proxy:
+// the module reads the requests in stdin and writes the results in the file descriptor 3.
+// The module, compiled for WASI, has only the environment of the action, and no file system nor network.
+func RunWasm(path string) error {
+	results := os.NewFile(3, "pipe")
+	defer results.Close()
+	return runWasm(context.Background(), path, os.Stdin, os.Stdout, os.Stderr, results, os.Environ())
+}
+
+// runWasm runs a WASM module, mapping the file descriptors 0, 1, 2 and 3 on the given streams
+func runWasm(ctx context.Context, path string, stdin io.Reader, stdout io.Writer, stderr io.Writer, results io.Writer, env []string) error {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+
+	// WASI, with the writes to file descriptors handled here, as the results are written in the descriptor 3,
+	// that in WASI is not a stream
+	wasi := r.NewHostModuleBuilder(wasi_snapshot_preview1.ModuleName)
+	wasi_snapshot_preview1.NewFunctionExporter().ExportFunctions(wasi)
+	files := map[uint32]io.Writer{1: stdout, 2: stderr, 3: results}
+	wasi.NewFunctionBuilder().
+		WithGoModuleFunction(wasiFdWrite(files), []api.ValueType{api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}).
+		WithParameterNames("fd", "iovs", "iovs_len", "result.nwritten").
+		Export("fd_write")
+	if _, err := wasi.Instantiate(ctx); err != nil {
+		return err
+	}
+
+	config := wazero.NewModuleConfig().
+		WithName("exec").
+		WithArgs("exec").
+		WithStdin(stdin).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			config = config.WithEnv(k, v)
+		}
+	}
+	mod, err := r.InstantiateWithConfig(ctx, code, config)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	var exitErr *wazerosys.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == 0 {
+			return nil
+		}
+		return fmt.Errorf("the module exited with code %d", exitErr.ExitCode())
+	}
+	return err
+}
+
+// wasiFdWrite implements fd_write of WASI on the given files
+func wasiFdWrite(files map[uint32]io.Writer) api.GoModuleFunc {
+	return func(ctx context.Context, mod api.Module, stack []uint64) {
+		fd, iovs, iovsLen, resultNwritten := uint32(stack[0]), uint32(stack[1]), uint32(stack[2]), uint32(stack[3])
+		stack[0] = wasiErrnoSuccess
+		w, ok := files[fd]
+		if !ok {
+			stack[0] = wasiErrnoBadf
+			return
+		}
+		mem := mod.Memory()
+		var written uint32
+		for i := uint32(0); i < iovsLen; i++ {
+			offset, ok1 := mem.ReadUint32Le(iovs + i*8)
+			length, ok2 := mem.ReadUint32Le(iovs + i*8 + 4)
+			buf, ok3 := mem.Read(offset, length)
+			if !ok1 || !ok2 || !ok3 {
+				stack[0] = wasiErrnoFault
+				return
+			}
+			n, err := w.Write(buf)
+			written += uint32(n)
+			if err != nil {
+				stack[0] = wasiErrnoIO
+				break
+			}
+		}
+		if !mem.WriteUint32Le(resultNwritten, written) {
+			stack[0] = wasiErrnoFault
+		}
+	}
+}
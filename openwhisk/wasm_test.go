@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopWasm builds _test/loop.src for WASI once, returning the path of the module; it is built
+// in a directory of the module of the proxy, as it imports the actionloop package
+var loopWasm = sync.OnceValues(func() (string, error) {
+	src, err := os.MkdirTemp("_test", "wasm")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(src)
+	out, err := os.MkdirTemp("", "wasm")
+	if err != nil {
+		return "", err
+	}
+	code, err := os.ReadFile("_test/loop.src")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(src, "loop.go"), code, 0644); err != nil {
+		return "", err
+	}
+	module := filepath.Join(out, "loop.wasm")
+	cmd := exec.Command("go", "build", "-o", module, "loop.go")
+	cmd.Dir = src
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if buf, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("cannot build the module: %v\n%s", err, buf)
+	}
+	return module, nil
+})
+
+func Example_wasm() {
+	module, err := loopWasm()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ts, cur, log := startTestServer("")
+	doInit(ts, initBinary(module, ""))
+	doContextRun(ts, `{"value":{"name":"Mike"},"activation_id":"a1"}`)
+	doContextRun(ts, `{"value":{}}`)
+	stopTestServer(ts, cur, log)
+	// Output:
+	// 200 {"ok":true}
+	// 200 {"hello":"Mike"}
+	// 200 {"error":"missing name"}
+	// activation=a1
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+	// XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX
+}
+
+func TestRunWasm(t *testing.T) {
+	module, err := loopWasm()
+	require.NoError(t, err)
+	stdin := bytes.NewBufferString(`{"value":{"name":"Mike"},"activation_id":"a2"}` + "\n")
+	var stdout, stderr, results bytes.Buffer
+	err = runWasm(context.Background(), module, stdin, &stdout, &stderr, &results, []string{"__OW_ACTION_NAME=/ns/loop"})
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"hello\":\"Mike\"}\n", results.String())
+	assert.Equal(t, "activation=a2\n", stdout.String())
+
+	err = runWasm(context.Background(), "_test/hi", stdin, &stdout, &stderr, &results, nil)
+	assert.Error(t, err)
+}
+
+func TestWasmCommand(t *testing.T) {
+	module, err := loopWasm()
+	require.NoError(t, err)
+	_, _, ok := wasmCommand("_test/hi")
+	assert.False(t, ok)
+	command, args, ok := wasmCommand(module)
+	assert.True(t, ok)
+	assert.NotEmpty(t, command)
+	assert.Equal(t, []string{WasmFlag, module}, args)
+	_, _, ok = wasmCommand("_test/missing.wasm")
+	assert.False(t, ok)
+}
@@ -0,0 +1,151 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is how often WatchAction checks the sources for changes
+var DefaultWatchInterval = 500 * time.Millisecond
+
+// fileStamp is what changes in a file when it is written
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// sourceSnapshot stamps the files in path, a file or a directory, skipping the hidden ones
+func sourceSnapshot(path string) (map[string]fileStamp, error) {
+	snapshot := map[string]fileStamp{}
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != path && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		snapshot[name] = fileStamp{info.Size(), info.ModTime()}
+		return nil
+	})
+	return snapshot, err
+}
+
+// WatchAction initializes the action in path, a file or a directory as for RunLocal, then initializes it
+// again every time it changes, until the context is done. The outcome of every initialization, with
+// the errors of the compiler, is written to console; the last version that started keeps serving the runs.
+func (ap *ActionProxy) WatchAction(ctx context.Context, path string, main string, env map[string]interface{}, console io.Writer) error {
+	last, err := sourceSnapshot(path)
+	if err != nil {
+		return err
+	}
+	if main == "" {
+		main = "main"
+	}
+	ap.initMu.Lock()
+	ap.SetEnv(env)
+	ap.initialized = true
+	ap.initMu.Unlock()
+	ap.reloadAction(ctx, path, main, console)
+
+	// the action is reloaded when the sources did not change for an interval, as editors write many files
+	ticker := time.NewTicker(DefaultWatchInterval)
+	defer ticker.Stop()
+	changed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		snapshot, err := sourceSnapshot(path)
+		if err != nil {
+			Debug("cannot watch %s: %v", path, err)
+			continue
+		}
+		if !maps.Equal(snapshot, last) {
+			last, changed = snapshot, true
+			continue
+		}
+		if changed {
+			changed = false
+			ap.reloadAction(ctx, path, main, console)
+		}
+	}
+}
+
+// reloadAction extracts, compiles and starts the action in path, reporting to console;
+// it waits for an /init in progress, as the requests are served while watching
+func (ap *ActionProxy) reloadAction(ctx context.Context, path string, main string, console io.Writer) {
+	ap.initMu.Lock()
+	defer ap.initMu.Unlock()
+	fmt.Fprintf(console, "loading %s\n", path)
+	start := time.Now()
+	extracted := ap.currentDir
+	src, err := openLocalAction(path)
+	if err == nil {
+		_, err = ap.ExtractAndCompileFromContext(ctx, src, main)
+		src.Close()
+		// the sources that do not compile are not kept
+		if err != nil && ap.currentDir > extracted {
+			os.RemoveAll(fmt.Sprintf("%s/%d", ap.baseDir, ap.currentDir))
+		}
+	}
+	if err == nil {
+		err = ap.StartLatestAction()
+	}
+	if err == nil {
+		fmt.Fprintf(console, "the action is ready in %v\n", time.Since(start).Round(time.Millisecond))
+		return
+	}
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		fmt.Fprintln(console, "the action does not compile:")
+		diagnostics := compileErr.Diagnostics
+		if len(diagnostics) == 0 {
+			diagnostics = []Diagnostic{{Message: strings.TrimSpace(compileErr.Error())}}
+		}
+		for _, d := range diagnostics {
+			fmt.Fprintf(console, "  %s\n", d)
+		}
+	} else {
+		fmt.Fprintf(console, "the action cannot be loaded: %s\n", strings.TrimSpace(err.Error()))
+	}
+	if ap.versions.running() {
+		fmt.Fprintln(console, "the previous version is still serving")
+	}
+}
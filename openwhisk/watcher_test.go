@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openwhisk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer written by a goroutine and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// emitCompiler compiles a source with a value in an action emitting it, failing on "error"
+var emitCompiler = CompilerFunc(func(ctx context.Context, main, srcDir, binDir string, env map[string]string) (CompileResult, error) {
+	src, err := os.ReadFile(srcDir + "/exec")
+	if err != nil {
+		return CompileResult{}, err
+	}
+	value := strings.TrimSpace(string(src))
+	if value == "error" {
+		return CompileResult{}, &CompileError{ExitCode: 1, Diagnostics: []Diagnostic{{File: "exec", Line: 1, Severity: "error", Message: "not a value"}}}
+	}
+	script := "#!/bin/sh\nwhile read line; do echo " + value + " >&3; done\n"
+	return CompileResult{Executable: binDir + "/exec"}, os.WriteFile(binDir+"/exec", []byte(script), 0755)
+})
+
+func TestSourceSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/exec", []byte("1"), 0644)
+	os.Mkdir(dir+"/.git", 0755)
+	os.WriteFile(dir+"/.git/HEAD", []byte("ref"), 0644)
+	os.WriteFile(dir+"/.swp", []byte("x"), 0644)
+	snapshot, err := sourceSnapshot(dir)
+	assert.NoError(t, err)
+	assert.Len(t, snapshot, 1)
+	assert.Contains(t, snapshot, dir+"/exec")
+
+	// a single file
+	snapshot, err = sourceSnapshot(dir + "/exec")
+	assert.NoError(t, err)
+	assert.Len(t, snapshot, 1)
+
+	_, err = sourceSnapshot(dir + "/missing")
+	assert.Error(t, err)
+}
+
+func TestWatchAction(t *testing.T) {
+	interval := DefaultWatchInterval
+	DefaultWatchInterval = 100 * time.Millisecond
+	defer func() { DefaultWatchInterval = interval }()
+
+	src := t.TempDir()
+	os.WriteFile(src+"/exec", []byte("1"), 0644)
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	ap.SetCompiler(emitCompiler)
+	defer ap.versions.stop()
+	var console syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ap.WatchAction(ctx, src, "main", nil, &console)
+	}()
+	assert.Eventually(t, func() bool { return emitted(ap) == "1\n" }, 10*time.Second, 100*time.Millisecond)
+
+	// a change is loaded
+	os.WriteFile(src+"/exec", []byte("22"), 0644)
+	assert.Eventually(t, func() bool { return emitted(ap) == "22\n" }, 10*time.Second, 100*time.Millisecond)
+
+	// an error is reported, while the last good version keeps serving
+	os.WriteFile(src+"/exec", []byte("error"), 0644)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(console.String(), "the previous version is still serving")
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.Equal(t, "22\n", emitted(ap))
+	assert.Contains(t, console.String(), "the action does not compile:\n  exec:1: error: not a value\n")
+
+	// and fixed
+	os.WriteFile(src+"/exec", []byte("333"), 0644)
+	assert.Eventually(t, func() bool { return emitted(ap) == "333\n" }, 10*time.Second, 100*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 4, strings.Count(console.String(), "loading "+src+"\n"))
+	assert.Equal(t, 3, strings.Count(console.String(), "the action is ready in"))
+}
+
+func TestWatchAction_missing(t *testing.T) {
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	assert.Error(t, ap.WatchAction(context.Background(), t.TempDir()+"/missing", "main", nil, os.Stderr))
+}
+
+func TestWatchAction_init(t *testing.T) {
+	interval := DefaultWatchInterval
+	DefaultWatchInterval = 50 * time.Millisecond
+	defer func() { DefaultWatchInterval = interval }()
+	// multiple inits are allowed when debugging
+	Debugging = true
+	defer func() { Debugging = false }()
+
+	src := t.TempDir()
+	os.WriteFile(src+"/exec", []byte("1"), 0644)
+	ap := NewActionProxy(testConfig(t.TempDir(), "", ProxyModeNone), os.Stdout, os.Stderr)
+	ap.SetCompiler(emitCompiler)
+	defer ap.versions.stop()
+	ts := httptest.NewServer(ap)
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ap.WatchAction(ctx, src, "main", nil, io.Discard)
+	}()
+
+	// the reloads and the inits run one at a time, each in a new directory
+	for i := 0; i < 5; i++ {
+		os.WriteFile(src+"/exec", []byte(strconv.Itoa(10+i)), 0644)
+		_, status, err := doPost(ts.URL+"/init", `{"value":{"code":"`+strconv.Itoa(20+i)+`"}}`)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		time.Sleep(60 * time.Millisecond)
+	}
+	os.WriteFile(src+"/exec", []byte("99"), 0644)
+	assert.Eventually(t, func() bool { return emitted(ap) == "99\n" }, 10*time.Second, 50*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+}
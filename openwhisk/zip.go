@@ -19,17 +19,16 @@ package openwhisk
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func openZip(src []byte) *zip.Reader {
-	reader := bytes.NewReader(src)
-	r, err := zip.NewReader(reader, int64(len(src)))
+func openZip(src io.ReaderAt, size int64) *zip.Reader {
+	r, err := zip.NewReader(src, size)
 	if err != nil {
 		return nil
 	}
@@ -40,28 +39,55 @@ func openZip(src []byte) *zip.Reader {
 // if it is a jar file, save it as the file jarFile
 // Otherwise unzip the files in the destination dir
 func UnzipOrSaveJar(src []byte, dest string, jarFile string) error {
-	r := openZip(src)
+	return UnzipOrSaveJarFrom(bytes.NewReader(src), int64(len(src)), dest, jarFile)
+}
+
+// UnzipOrSaveJarFrom is like UnzipOrSaveJar but reads the zip file from a ReaderAt of the given size
+func UnzipOrSaveJarFrom(src io.ReaderAt, size int64, dest string, jarFile string) error {
+	return unzipOrSaveJar(src, size, dest, jarFile, nil)
+}
+
+func unzipOrSaveJar(src io.ReaderAt, size int64, dest string, jarFile string, budget *extractBudget) error {
+	r := openZip(src, size)
 	if r == nil {
 		return fmt.Errorf("not a zip file")
 	}
 	for _, f := range r.File {
 		if f.Name == "META-INF/MANIFEST.MF" {
-			os.WriteFile(jarFile, src, 0644)
-			return nil
+			return writeFile(jarFile, io.NewSectionReader(src, 0, size), 0644)
 		}
 	}
-	return Unzip(src, dest)
+	return unzip(src, size, dest, budget)
 }
 
 // Unzip extracts file and directories in the given destination folder
 func Unzip(src []byte, dest string) error {
-	r := openZip(src)
+	return UnzipFrom(bytes.NewReader(src), int64(len(src)), dest)
+}
+
+// UnzipFrom is like Unzip but reads the zip file from a ReaderAt of the given size
+func UnzipFrom(src io.ReaderAt, size int64, dest string) error {
+	return unzip(src, size, dest, nil)
+}
+
+// unzip extracts a zip file within the limits of the budget
+func unzip(src io.ReaderAt, size int64, dest string, budget *extractBudget) error {
+	r := openZip(src, size)
+	if r == nil {
+		return fmt.Errorf("not a zip file")
+	}
+	if budget != nil && budget.limits.Entries > 0 && int64(len(r.File)) > budget.limits.Entries {
+		return &ArchiveLimitError{fmt.Sprintf("the action archive has more than %d entries", budget.limits.Entries)}
+	}
 	os.MkdirAll(dest, 0755)
 	// Closure to address file descriptors issue with all the deferred .Close() methods
 	extractAndWriteFile := func(f *zip.File) error {
 
 		path := filepath.Join(dest, f.Name)
 		isLink := f.FileInfo().Mode()&os.ModeSymlink == os.ModeSymlink
+		if err := budget.entry(f.Name, int64(f.UncompressedSize64)); err != nil {
+			return err
+		}
 
 		// dir
 		if f.FileInfo().IsDir() && !isLink {
@@ -95,13 +121,16 @@ func Unzip(src []byte, dest string) error {
 			return err
 		}
 		defer file.Close()
-		_, err = io.Copy(file, rc)
-		return err
+		return budget.copy(file, rc, f.Name)
 	}
 	for _, f := range r.File {
 		err := extractAndWriteFile(f)
+		var limitErr *ArchiveLimitError
+		if errors.As(err, &limitErr) {
+			return err
+		}
 		if err != nil {
-			log.Println(err)
+			Logger.Warn("cannot extract a file of the action", "file", f.Name, "error", err)
 		}
 	}
 	return nil
@@ -17,11 +17,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/apache/openserverless-runtimes/openwhisk"
 )
@@ -30,7 +34,7 @@ import (
 var version = flag.Bool("version", false, "show version")
 
 // flag to enable debug
-var debug = flag.Bool("debug", false, "enable debug output")
+var debug = flag.Bool("debug", false, "enable debugging the actions, with debug output unless OW_LOG_LEVEL is set")
 
 // flag to require on-the-fly compilation
 var compile = flag.String("compile", "", "compile, reading in standard input the specified function, and producing the result in stdout")
@@ -38,6 +42,30 @@ var compile = flag.String("compile", "", "compile, reading in standard input the
 // flag to pass an environment as a json string
 var env = flag.String("env", "", "pass an environment as a json string")
 
+// flag to run an action locally
+var run = flag.String("run", "", "run the action in the specified file, archive or directory with the JSON inputs in the arguments or in standard input, printing the results and the logs")
+
+// flag to replay a recording with the action to run
+var replay = flag.String("replay", "", "with -run, run the action with the requests recorded in the specified file, comparing the results")
+
+// flag to specify the main function of the action to run
+var mainFunc = flag.String("main", "main", "main function of the action to run")
+
+// flag to reload the action when it changes
+var watch = flag.String("watch", "", "initialize the action from the specified file or directory, and again every time it changes, for development")
+
+// flag to check a Go action, used by the compilers of the Go runtimes
+var check = flag.String("check", "", "check the signature of the function of the Go action in the specified directory, without running it")
+
+// flag to read the configuration from a file
+var configFile = flag.String("config", "", "read the configuration from the specified TOML or YAML file, overridden by the flags and the environment")
+
+// flag to print the configuration
+var printConfig = flag.Bool("print-config", false, "print the effective configuration, and where each setting comes from")
+
+// flags of the settings of the configuration
+var configFlags = openwhisk.ConfigFlags(flag.CommandLine)
+
 // fatal if error
 func fatalIf(err error) {
 	if err != nil {
@@ -45,9 +73,73 @@ func fatalIf(err error) {
 	}
 }
 
+// localConfig returns the configuration to run an action locally, in the given directory
+func localConfig(config *openwhisk.Config, dir string) *openwhisk.Config {
+	local := *config
+	local.ActionDir, local.ProxyMode = dir, openwhisk.ProxyModeNone
+	return &local
+}
+
+// replayLocal replays a recording with an action without a server, returning the exit code
+func replayLocal(config *openwhisk.Config, path string, recording string) int {
+	file, err := os.Open(recording)
+	fatalIf(err)
+	defer file.Close()
+	dir, err := os.MkdirTemp("", "action")
+	fatalIf(err)
+	defer os.RemoveAll(dir)
+
+	ap := openwhisk.NewActionProxy(localConfig(config, dir), os.Stdout, os.Stderr)
+	if err := ap.ReplayLocal(path, *mainFunc, envFlag(), file, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// envFlag returns the environment of the action passed with -env
+func envFlag() map[string]interface{} {
+	envMap := make(map[string]interface{})
+	if *env != "" {
+		fatalIf(json.Unmarshal([]byte(*env), &envMap))
+	}
+	return envMap
+}
+
+// runLocal runs an action without a server, returning the exit code
+func runLocal(config *openwhisk.Config, path string) int {
+	envMap := envFlag()
+	dir, err := os.MkdirTemp("", "action")
+	fatalIf(err)
+	defer os.RemoveAll(dir)
+
+	var inputs io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		inputs = strings.NewReader(strings.Join(flag.Args(), "\n"))
+	}
+	ap := openwhisk.NewActionProxy(localConfig(config, dir), os.Stdout, os.Stderr)
+	if err := ap.RunLocal(path, *mainFunc, envMap, inputs, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	// run the sandbox launcher or a WASM module, in the process started by the proxy for an action
+	openwhisk.RunChild()
+
 	flag.Parse()
 
+	// check the function of a Go action for its compiler
+	if *check != "" {
+		if err := openwhisk.CheckGoAction(*check, *mainFunc); err != nil {
+			fmt.Print(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// show version number
 	if *version {
 		fmt.Printf("OpenWhisk ActionLoop Proxy v%s, built with %s\n", openwhisk.Version, runtime.Version())
@@ -61,21 +153,29 @@ func main() {
 		os.Setenv("OW_DEBUG", "1")
 	}
 
-	proxyMode := openwhisk.ProxyModeNone
-	useProxyClient := os.Getenv("OW_ACTIVATE_PROXY_CLIENT")
-	if useProxyClient == "1" {
-		openwhisk.Debug("OW_ACTIVATE_PROXY_CLIENT set. Using runtime as a forward proxy.")
-		proxyMode = openwhisk.ProxyModeClient
+	// load the configuration, failing at startup if it is not valid
+	config, err := openwhisk.LoadConfig(*configFile, configFlags)
+	fatalIf(err)
+	if *printConfig {
+		fatalIf(config.Print(os.Stdout))
+		return
 	}
+	fatalIf(openwhisk.ConfigureLogging(config))
+
+	// reap the orphans of the actions if we are the init of the container
+	openwhisk.StartZombieReaper()
 
-	useProxyServer := os.Getenv("OW_ACTIVATE_PROXY_SERVER")
-	if useProxyServer == "1" {
-		openwhisk.Debug("OW_ACTIVATE_PROXY_SERVER set. Using runtime as a proxy server.")
-		proxyMode = openwhisk.ProxyModeServer
+	// run an action locally upon request
+	if *run != "" {
+		if *replay != "" {
+			os.Exit(replayLocal(config, *run, *replay))
+		}
+		os.Exit(runLocal(config, *run))
 	}
 
 	// create the action proxy
-	ap := openwhisk.NewActionProxy("./action", os.Getenv("OW_COMPILER"), os.Stdout, os.Stderr, proxyMode)
+	openwhisk.Debug("Using the runtime in the proxy mode %s.", config.ProxyMode)
+	ap := openwhisk.NewActionProxy(config, os.Stdout, os.Stderr)
 
 	// compile on the fly upon request
 	if *compile != "" {
@@ -83,12 +183,20 @@ func main() {
 		return
 	}
 
-	if useProxyClient == "1" {
-		// hook exit signals for remote action cleanup
-		go ap.HookExitSignals()
+	// reload the action when it changes upon request
+	if *watch != "" {
+		// the server keeps running also if the action cannot be watched
+		go func() {
+			if err := ap.WatchAction(context.Background(), *watch, *mainFunc, envFlag(), os.Stderr); err != nil {
+				log.Printf("cannot watch %s: %v", *watch, err)
+			}
+		}()
 	}
 
+	// hook exit signals for remote action cleanup and to export the last spans
+	go ap.HookExitSignals()
+
 	// start the balls rolling
 	openwhisk.Debug("OpenWhisk ActionLoop Proxy %s: starting", openwhisk.Version)
-	ap.Start(8080)
+	ap.Start(config.Port)
 }
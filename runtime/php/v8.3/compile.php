@@ -25,6 +25,9 @@
  * that the action proxy will call to start everything off
  */
 
+// diagnostics of the compilation, for the action proxy
+$diagnostics = [];
+
 main($argc, $argv);
 exit;
 
@@ -40,8 +43,40 @@ function main($argc, $argv)
 
     $shim = $bin.'/exec';
 
-    sources($src);
-    build($shim, $src, $main);
+    $ok = sources($src);
+    if ($ok) {
+        build($shim, $src, $main);
+    }
+    write_diagnostics();
+    exit($ok ? 0 : 1);
+}
+
+/**
+ * Record an error, also writing it in stderr
+ */
+function error(string $message, ?string $file = null, int $line = 0)
+{
+    global $diagnostics;
+    $diagnostic = ['severity' => 'error', 'message' => $message];
+    if ($file !== null) {
+        $diagnostic['file'] = $file;
+        $diagnostic['line'] = $line;
+    }
+    $diagnostics[] = $diagnostic;
+    fwrite(STDERR, $message . "\n");
+}
+
+/**
+ * Write the diagnostics if the proxy asks for them: this also tells the proxy
+ * to trust the exit code, so the warnings of composer are not an error
+ */
+function write_diagnostics()
+{
+    global $diagnostics;
+    $file = getenv('__OW_DIAGNOSTICS');
+    if ($file) {
+        file_put_contents($file, json_encode($diagnostics));
+    }
 }
 
 function write_file(string $filename, mixed $content, bool $executable = false)
@@ -64,9 +99,12 @@ function write_file(string $filename, mixed $content, bool $executable = false)
  * Sort out the source code
  *
  * 1. Copy src/exec to src/index.php if necessary
- * 2. Ensure vendor directory exists
+ * 2. Check the syntax of src/index.php
+ * 3. Ensure vendor directory exists
+ *
+ * Returns false if the action cannot be built.
  */
-function sources(string $src)
+function sources(string $src) : bool
 {
     // If the file uploaded by the user is a plain PHP file, then
     // the filename will be called exec by the action proxy.
@@ -75,15 +113,38 @@ function sources(string $src)
         rename($src . '/exec', $src . '/index.php');
     }
 
+    if (!file_exists($src . '/index.php')) {
+        error('Zip file does not include index.php');
+        return false;
+    }
+
+    // check the syntax, as "PHP Parse error: message in file on line N"
+    exec('php -l ' . escapeshellarg($src . '/index.php') . ' 2>&1', $output, $code);
+    if ($code != 0) {
+        $out = implode("\n", $output);
+        if (preg_match('/^(?:PHP )?(.*error: .*) in (.+) on line (\d+)$/m', $out, $m)) {
+            error($m[1], basename($m[2]), (int)$m[3]);
+        } else {
+            error($out);
+        }
+        return false;
+    }
+
     if (file_exists($src . '/composer.json')) {
         // Run composer if exists locally composer.json
-        exec("export HOME=$src; cd $src; composer install --no-progress --no-dev -o -q");
+        $output = [];
+        exec("export HOME=$src; cd $src; composer install --no-progress --no-dev -o -q 2>&1", $output, $code);
+        if ($code != 0) {
+            error("composer install failed: " . implode("\n", $output));
+            return false;
+        }
     }
 
     // put vendor in the right place if it doesn't exist
     if (!is_dir($src . '/vendor')) {
         exec('cp -a /phpAction/composer/vendor ' . escapeshellarg($src . '/vendor'));
     }
+    return true;
 }
 
 /**
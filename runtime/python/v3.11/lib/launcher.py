@@ -19,7 +19,7 @@ from sys import stdin
 from sys import stdout
 from sys import stderr
 from os import fdopen
-import sys, os, json, traceback, warnings
+import sys, os, json, traceback, warnings, inspect, base64
 
 try:
   # if the directory 'virtualenv' is extracted out of a zip file
@@ -63,9 +63,23 @@ while True:
   res = {}
   try:
     res = main(payload)
+    # a generator streams its items as chunks, then returns the result
+    if inspect.isgenerator(res):
+      gen, res = res, {}
+      try:
+        while True:
+          chunk = next(gen)
+          out.write(json.dumps({"__ow_chunk": chunk}, ensure_ascii=False).encode('utf-8'))
+          out.write(b'\n')
+          out.flush()
+      except StopIteration as stop:
+        res = stop.value if stop.value is not None else {}
   except Exception as ex:
     print(traceback.format_exc(), file=stderr)
     res = {"error": str(ex)}
+  # bytes are not JSON, they are sent in a result envelope
+  if isinstance(res, (bytes, bytearray)):
+    res = {"__ow_result": {"body": base64.b64encode(res).decode('ascii'), "encoding": "base64"}}
   out.write(json.dumps(res, ensure_ascii=False).encode('utf-8'))
   out.write(b'\n')
   stdout.flush()